@@ -0,0 +1,90 @@
+package availability
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/frado1/libs/servicecheck"
+)
+
+// Config describes the services to probe and how often to probe them
+type Config struct {
+	// Interval between two probes, defaults to 30 seconds
+	Interval time.Duration `yaml:"interval"`
+	// FailureThreshold is the number of failed probes until a service is down, defaults to 3
+	FailureThreshold int `yaml:"failurethreshold"`
+	// SuccessThreshold is the number of successful probes until a service is up again, defaults to 1
+	SuccessThreshold int             `yaml:"successthreshold"`
+	Services         []ServiceConfig `yaml:"services"`
+}
+
+// ServiceConfig describes a single service
+//
+// Type selects the probe and defaults to tcp:
+//
+//	tcp, udp  opens a connection to Address
+//	http      requests the URL in Address and checks Status and Expect against the response
+//	tls       connects to Address and checks the certificate to be valid at least MinValidity
+//	line      connects to Address, writes Send and checks that the first line starts with Expect
+type ServiceConfig struct {
+	Name    string        `yaml:"name"`
+	Type    string        `yaml:"type"`
+	Address string        `yaml:"address"`
+	Timeout time.Duration `yaml:"timeout"`
+
+	Method             string        `yaml:"method"`
+	Status             int           `yaml:"status"`
+	Send               string        `yaml:"send"`
+	Expect             string        `yaml:"expect"`
+	Pattern            string        `yaml:"pattern"`
+	MinValidity        time.Duration `yaml:"minvalidity"`
+	InsecureSkipVerify bool          `yaml:"insecureskipverify"`
+}
+
+// Probe creates the probe for the service
+func (c ServiceConfig) Probe() (servicecheck.Probe, error) {
+	if c.Name == "" {
+		return nil, fmt.Errorf("Name of service with address %s is missing", c.Address)
+	}
+	if c.Address == "" {
+		return nil, fmt.Errorf("Address of service %s is missing", c.Name)
+	}
+
+	switch c.Type {
+	case "", "tcp", "udp":
+		network := c.Type
+		if network == "" {
+			network = "tcp"
+		}
+		return servicecheck.DialProbe{Network: network, Address: c.Address, Timeout: c.Timeout}, nil
+	case "http":
+		p := servicecheck.HTTPProbe{
+			URL:                c.Address,
+			Method:             c.Method,
+			ExpectedStatus:     c.Status,
+			BodyContains:       c.Expect,
+			InsecureSkipVerify: c.InsecureSkipVerify,
+			Timeout:            c.Timeout,
+		}
+		if c.Pattern != "" {
+			pattern, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("Pattern of service %s is invalid: %s", c.Name, err)
+			}
+			p.BodyPattern = pattern
+		}
+		return p, nil
+	case "tls":
+		return servicecheck.TLSProbe{
+			Address:            c.Address,
+			MinValidity:        c.MinValidity,
+			InsecureSkipVerify: c.InsecureSkipVerify,
+			Timeout:            c.Timeout,
+		}, nil
+	case "line":
+		return servicecheck.LineProbe{Network: "tcp", Address: c.Address, Send: c.Send, Expect: c.Expect, Timeout: c.Timeout}, nil
+	default:
+		return nil, fmt.Errorf("Type '%s' of service %s is not valid", c.Type, c.Name)
+	}
+}
@@ -0,0 +1,74 @@
+package availability
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/frado1/libs/servicecheck"
+)
+
+func TestServiceConfigProbe(t *testing.T) {
+	tests := []struct {
+		config ServiceConfig
+		probe  servicecheck.Probe
+	}{
+		{
+			ServiceConfig{Name: "nas", Address: "nas.local:445"},
+			servicecheck.DialProbe{Network: "tcp", Address: "nas.local:445"},
+		},
+		{
+			ServiceConfig{Name: "dns", Type: "udp", Address: "router.local:53", Timeout: time.Second},
+			servicecheck.DialProbe{Network: "udp", Address: "router.local:53", Timeout: time.Second},
+		},
+		{
+			ServiceConfig{Name: "kodi", Type: "http", Address: "http://kodi.local:8080/jsonrpc", Method: "POST", Status: 200, Expect: "jsonrpc"},
+			servicecheck.HTTPProbe{URL: "http://kodi.local:8080/jsonrpc", Method: "POST", ExpectedStatus: 200, BodyContains: "jsonrpc"},
+		},
+		{
+			ServiceConfig{Name: "mqtt-tls", Type: "tls", Address: "broker.local:8883", MinValidity: 336 * time.Hour},
+			servicecheck.TLSProbe{Address: "broker.local:8883", MinValidity: 336 * time.Hour},
+		},
+		{
+			ServiceConfig{Name: "mpd", Type: "line", Address: "mpd.local:6600", Expect: "OK MPD"},
+			servicecheck.LineProbe{Network: "tcp", Address: "mpd.local:6600", Expect: "OK MPD"},
+		},
+	}
+
+	for _, test := range tests {
+		p, err := test.config.Probe()
+		if err != nil {
+			t.Errorf("Probe of service %s failed: %s", test.config.Name, err)
+			continue
+		}
+		if !reflect.DeepEqual(p, test.probe) {
+			t.Errorf("Service %s has probe %#v instead of %#v", test.config.Name, p, test.probe)
+		}
+	}
+}
+
+func TestServiceConfigProbePattern(t *testing.T) {
+	p, err := ServiceConfig{Name: "kodi", Type: "http", Address: "http://kodi.local:8080", Pattern: `"version":\d+`}.Probe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, ok := p.(servicecheck.HTTPProbe)
+	if !ok || h.BodyPattern == nil || !h.BodyPattern.MatchString(`{"version":17}`) {
+		t.Fatalf("Unexpected probe %#v", p)
+	}
+}
+
+func TestServiceConfigProbeErrors(t *testing.T) {
+	configs := []ServiceConfig{
+		{Address: "nas.local:445"},
+		{Name: "nas"},
+		{Name: "nas", Type: "smb", Address: "nas.local:445"},
+		{Name: "kodi", Type: "http", Address: "http://kodi.local:8080", Pattern: "("},
+	}
+
+	for _, c := range configs {
+		if p, err := c.Probe(); err == nil {
+			t.Errorf("Config %#v returned probe %#v", c, p)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package availability
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/frado1/libs/mqtthelper"
+	"github.com/frado1/libs/servicecheck"
+)
+
+// Payload is the JSON status published for every service
+type Payload struct {
+	State string `json:"state"`
+	// Latency of the last probe in milliseconds
+	Latency    float64   `json:"latency"`
+	LastChange time.Time `json:"lastchange"`
+	LastError  string    `json:"lasterror,omitempty"`
+}
+
+// NewPayload converts the status of a service to its payload
+func NewPayload(s servicecheck.ServiceStatus) Payload {
+	p := Payload{
+		State:      s.Status.String(),
+		Latency:    float64(s.Latency) / float64(time.Millisecond),
+		LastChange: s.LastChange,
+	}
+	if s.Status == servicecheck.Down && s.LastError != nil {
+		p.LastError = s.LastError.Error()
+	}
+
+	return p
+}
+
+// Publisher probes the configured services and publishes their status on <top>/status/<name>
+//
+// The status is published retained whenever a service goes up or down
+// and for all services again after every (re)connect to MQTT.
+type Publisher struct {
+	broker    *mqtthelper.SmartHomeBroker
+	monitor   *servicecheck.Monitor
+	connected chan struct{}
+	once      *sync.Once
+}
+
+// NewPublisher creates a publisher for the services of the config
+//
+// It takes over the OnConnectHandler of the broker, but calls a previously set one first.
+func NewPublisher(b *mqtthelper.SmartHomeBroker, c Config) (*Publisher, error) {
+	interval := c.Interval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	m := servicecheck.NewMonitor(interval)
+	if c.FailureThreshold > 0 {
+		m.FailureThreshold = c.FailureThreshold
+	}
+	if c.SuccessThreshold > 0 {
+		m.SuccessThreshold = c.SuccessThreshold
+	}
+	for _, s := range c.Services {
+		p, err := s.Probe()
+		if err != nil {
+			return nil, err
+		}
+		m.Add(s.Name, p)
+	}
+
+	p := &Publisher{broker: b, monitor: m, connected: make(chan struct{}), once: &sync.Once{}}
+	m.OnChange = func(e servicecheck.Event) {
+		p.publish(e.ServiceStatus)
+	}
+
+	onConnect := b.OnConnectHandler
+	b.OnConnectHandler = func(b *mqtthelper.SmartHomeBroker) {
+		if onConnect != nil {
+			onConnect(b)
+		}
+		p.once.Do(func() {
+			close(p.connected)
+		})
+		p.publishAll()
+	}
+
+	return p, nil
+}
+
+// Monitor returns the monitor which probes the services
+func (p *Publisher) Monitor() *servicecheck.Monitor {
+	return p.monitor
+}
+
+// Run probes the services until the context is done
+//
+// Probing starts once the broker connected to MQTT for the first time, so it can be called before connecting.
+func (p *Publisher) Run(ctx context.Context) {
+	select {
+	case <-p.connected:
+	case <-ctx.Done():
+		return
+	}
+
+	p.monitor.Run(ctx)
+}
+
+func (p *Publisher) publishAll() {
+	for _, s := range p.monitor.Statuses() {
+		if s.Status != servicecheck.Unknown {
+			p.publish(s)
+		}
+	}
+}
+
+func (p *Publisher) publish(s servicecheck.ServiceStatus) {
+	if err := p.broker.PublishStatus(s.Name, NewPayload(s)); err != nil {
+		log.Printf("Could not publish status of service %s: %s", s.Name, err)
+	}
+}
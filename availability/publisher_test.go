@@ -0,0 +1,74 @@
+package availability
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frado1/libs/mqtthelper"
+	"github.com/frado1/libs/servicecheck"
+)
+
+func TestNewPayload(t *testing.T) {
+	lastChange := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	p := NewPayload(servicecheck.ServiceStatus{
+		Name:       "nas",
+		Status:     servicecheck.Down,
+		Latency:    1500 * time.Microsecond,
+		LastChange: lastChange,
+		LastError:  errors.New("connection refused"),
+	})
+	expected := Payload{State: servicecheck.Down.String(), Latency: 1.5, LastChange: lastChange, LastError: "connection refused"}
+	if p != expected {
+		t.Fatalf("Unexpected payload %#v of service which is down", p)
+	}
+
+	// the error of a single failed probe is not reported while the service is still up
+	p = NewPayload(servicecheck.ServiceStatus{
+		Name:       "nas",
+		Status:     servicecheck.Up,
+		Latency:    20 * time.Millisecond,
+		LastChange: lastChange,
+		LastError:  errors.New("timeout"),
+	})
+	expected = Payload{State: servicecheck.Up.String(), Latency: 20, LastChange: lastChange}
+	if p != expected {
+		t.Fatalf("Unexpected payload %#v of service which is up", p)
+	}
+}
+
+func TestPublisherRunWaitsForConnection(t *testing.T) {
+	b := mqtthelper.NewSmartHomeBroker("tcp://localhost:1883", "availability")
+	p, err := NewPublisher(b, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	probed := make(chan struct{}, 1)
+	p.Monitor().Add("nas", servicecheck.ProbeFunc(func(ctx context.Context) error {
+		probed <- struct{}{}
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Run(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-probed:
+		t.Fatal("Services were probed before the broker connected")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after the context was cancelled")
+	}
+}
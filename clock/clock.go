@@ -0,0 +1,168 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock abstracts the passing of time, so it can be replaced in tests
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer represents a single event created by AfterFunc
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// System is the clock of the operating system
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// Fake is a clock which only moves when it is advanced manually
+type Fake struct {
+	now     time.Time
+	waiters []*fakeWaiter
+	mutex   *sync.Mutex
+}
+
+type fakeWaiter struct {
+	clock    *Fake
+	deadline time.Time
+	ch       chan time.Time
+	f        func()
+	active   bool
+}
+
+// NewFake creates a fake clock starting at the given time
+func NewFake(now time.Time) *Fake {
+	return &Fake{
+		now:   now,
+		mutex: &sync.Mutex{},
+	}
+}
+
+// Now returns the current time of the fake clock
+func (c *Fake) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// After returns a channel which receives the time once the clock was advanced by the duration
+func (c *Fake) After(d time.Duration) <-chan time.Time {
+	w := &fakeWaiter{clock: c, ch: make(chan time.Time, 1)}
+	w.Reset(d)
+
+	return w.ch
+}
+
+// AfterFunc calls the function once the clock was advanced by the duration
+//
+// The function is called synchronously by Advance.
+func (c *Fake) AfterFunc(d time.Duration, f func()) Timer {
+	w := &fakeWaiter{clock: c, f: f}
+	w.Reset(d)
+
+	return w
+}
+
+// Pending returns the number of timers which did not fire yet, e.g. to wait until a goroutine started waiting
+func (c *Fake) Pending() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return len(c.waiters)
+}
+
+// Advance moves the clock forward and fires all timers which are due in the order of their deadlines
+func (c *Fake) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	c.mutex.Unlock()
+
+	for {
+		c.mutex.Lock()
+		next := -1
+		for i, w := range c.waiters {
+			if next < 0 || w.deadline.Before(c.waiters[next].deadline) {
+				next = i
+			}
+		}
+		if next < 0 || c.waiters[next].deadline.After(target) {
+			c.now = target
+			c.mutex.Unlock()
+			return
+		}
+
+		w := c.waiters[next]
+		c.remove(w)
+		if w.deadline.After(c.now) {
+			c.now = w.deadline
+		}
+		now := c.now
+		c.mutex.Unlock()
+
+		if w.f != nil {
+			w.f()
+		} else {
+			w.ch <- now
+		}
+	}
+}
+
+// Stop prevents the timer from firing and returns whether it was still pending
+func (w *fakeWaiter) Stop() bool {
+	c := w.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.remove(w)
+}
+
+// Reset lets the timer fire after the duration and returns whether it was still pending
+func (w *fakeWaiter) Reset(d time.Duration) bool {
+	c := w.clock
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	pending := c.remove(w)
+	w.deadline = c.now.Add(d)
+	w.active = true
+	c.waiters = append(c.waiters, w)
+
+	return pending
+}
+
+func (c *Fake) remove(w *fakeWaiter) bool {
+	if !w.active {
+		return false
+	}
+
+	for i, registered := range c.waiters {
+		if registered == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			break
+		}
+	}
+	w.active = false
+
+	return true
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/frado1/libs/availability"
+	"github.com/frado1/libs/mqtthelper"
+)
+
+type config struct {
+	MQTT struct {
+		mqtthelper.ClientOptions `yaml:",inline"`
+		TopLevelTopic            string `yaml:"toplevel"`
+		QoS                      byte   `yaml:"qos"`
+	} `yaml:"mqtt"`
+	availability.Config `yaml:",inline"`
+}
+
+func main() {
+	c := config{}
+	if err := mqtthelper.ParseConfigOption(&c); err != nil {
+		log.Fatal(err)
+	}
+
+	b := mqtthelper.NewSmartHomeBroker(c.MQTT.URI, c.MQTT.TopLevelTopic)
+	b.Options = c.MQTT.ClientOptions
+	b.QoS = c.MQTT.QoS
+	b.ConnectedQoS = c.MQTT.QoS
+	b.OnConnectHandler = func(b *mqtthelper.SmartHomeBroker) {
+		b.SetConnectionState(true)
+	}
+
+	p, err := availability.NewPublisher(b, c.Config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Run(ctx)
+
+	if err := b.RunContext(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
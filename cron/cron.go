@@ -0,0 +1,347 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchDays limits the search for the next activation, e.g. for the 29th of February
+const maxSearchDays = 5 * 366
+
+// Schedule calculates the activations of a recurring event
+type Schedule interface {
+	// Next returns the first activation after the given time or the zero time if there is none
+	Next(after time.Time) time.Time
+}
+
+// Parser parses schedules, it can be part of the config loaded by mqtthelper.LoadConfig
+//
+// The following expressions are supported:
+//
+//	30 6 * * 1-5     cron expressions with the fields minute, hour, day of month, month and day of week
+//	@daily           the macros @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly
+//	@sunrise+15m     sunrise or sunset with an optional offset, this requires the coordinates
+//
+// Every expression can be prefixed with a time zone like "CRON_TZ=Europe/Berlin ", otherwise Location is used.
+type Parser struct {
+	// Location defaults to the local time zone
+	Location  *time.Location `yaml:"-"`
+	TimeZone  string         `yaml:"timezone"`
+	Latitude  float64        `yaml:"latitude"`
+	Longitude float64        `yaml:"longitude"`
+}
+
+// Parse parses the expression in the local time zone, astronomical events are not supported
+func Parse(expr string) (Schedule, error) {
+	return Parser{}.Parse(expr)
+}
+
+// Parse parses the expression
+func (p Parser) Parse(expr string) (Schedule, error) {
+	loc, err := p.location()
+	if err != nil {
+		return nil, err
+	}
+
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "CRON_TZ=") || strings.HasPrefix(expr, "TZ=") {
+		i := strings.IndexAny(expr, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("Expression '%s' contains only a time zone", expr)
+		}
+		name := expr[strings.Index(expr, "=")+1 : i]
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("Time zone %s of expression '%s' is not valid: %s", name, expr, err)
+		}
+		expr = strings.TrimSpace(expr[i:])
+	}
+
+	if strings.HasPrefix(expr, "@sunrise") || strings.HasPrefix(expr, "@sunset") {
+		return p.parseSun(expr, loc)
+	}
+
+	switch expr {
+	case "@yearly", "@annually":
+		expr = "0 0 1 1 *"
+	case "@monthly":
+		expr = "0 0 1 * *"
+	case "@weekly":
+		expr = "0 0 * * 0"
+	case "@daily", "@midnight":
+		expr = "0 0 * * *"
+	case "@hourly":
+		expr = "0 * * * *"
+	}
+
+	return parseSpec(expr, loc)
+}
+
+func (p Parser) location() (*time.Location, error) {
+	if p.TimeZone != "" {
+		loc, err := time.LoadLocation(p.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("Time zone %s is not valid: %s", p.TimeZone, err)
+		}
+		return loc, nil
+	}
+	if p.Location != nil {
+		return p.Location, nil
+	}
+
+	return time.Local, nil
+}
+
+func (p Parser) parseSun(expr string, loc *time.Location) (Schedule, error) {
+	if p.Latitude == 0 && p.Longitude == 0 {
+		return nil, fmt.Errorf("Expression '%s' requires the latitude and longitude", expr)
+	}
+
+	s := SunSchedule{Latitude: p.Latitude, Longitude: p.Longitude, Location: loc}
+	rest := ""
+	if strings.HasPrefix(expr, "@sunrise") {
+		s.Event = Sunrise
+		rest = strings.TrimPrefix(expr, "@sunrise")
+	} else {
+		s.Event = Sunset
+		rest = strings.TrimPrefix(expr, "@sunset")
+	}
+
+	if rest != "" {
+		if rest[0] != '+' && rest[0] != '-' {
+			return nil, fmt.Errorf("Offset of expression '%s' has to start with + or -", expr)
+		}
+		offset, err := time.ParseDuration(rest)
+		if err != nil {
+			return nil, fmt.Errorf("Offset of expression '%s' is not valid: %s", expr, err)
+		}
+		s.Offset = offset
+	}
+
+	return s, nil
+}
+
+// spec is a parsed cron expression, every field is a bit set of the allowed values
+type spec struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny are set for the wildcard *, if both fields are restricted a day has to match one of them
+	domAny, dowAny bool
+	// hourAny lets ambiguous local times at the end of daylight saving time activate twice
+	hourAny  bool
+	location *time.Location
+}
+
+type field struct {
+	min, max int
+	names    []string
+}
+
+var (
+	minuteField = field{min: 0, max: 59}
+	hourField   = field{min: 0, max: 23}
+	domField    = field{min: 1, max: 31}
+	monthField  = field{min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	dowField    = field{min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+func parseSpec(expr string, loc *time.Location) (*spec, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Expression '%s' has %d fields, expected 5", expr, len(fields))
+	}
+
+	s := &spec{
+		domAny:   fields[2] == "*" || fields[2] == "?",
+		dowAny:   fields[4] == "*" || fields[4] == "?",
+		hourAny:  fields[1] == "*",
+		location: loc,
+	}
+
+	var err error
+	if s.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, fmt.Errorf("Minute of expression '%s' is not valid: %s", expr, err)
+	}
+	if s.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, fmt.Errorf("Hour of expression '%s' is not valid: %s", expr, err)
+	}
+	if s.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, fmt.Errorf("Day of month of expression '%s' is not valid: %s", expr, err)
+	}
+	if s.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, fmt.Errorf("Month of expression '%s' is not valid: %s", expr, err)
+	}
+	if s.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, fmt.Errorf("Day of week of expression '%s' is not valid: %s", expr, err)
+	}
+	// 7 is an alias for sunday
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+
+	return s, nil
+}
+
+// parse parses a comma separated list of values, ranges and steps like 1,5-10,*/15
+func (f field) parse(s string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("Step '%s' is not valid", part[i+1:])
+			}
+			step = n
+			part = part[:i]
+		}
+
+		from, to := f.min, f.max
+		if part != "*" && part != "?" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = f.value(bounds[1]); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				to = f.max
+			}
+			if from > to {
+				return 0, fmt.Errorf("Range '%s' is empty", part)
+			}
+		}
+
+		for v := from; v <= to; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+func (f field) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.ToLower(s) == name {
+			return i + f.min, nil
+		}
+	}
+
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("Value '%s' is not a number", s)
+	}
+	if v < f.min || v > f.max {
+		return 0, fmt.Errorf("Value %d is not between %d and %d", v, f.min, f.max)
+	}
+
+	return v, nil
+}
+
+// Next returns the first activation after the given time
+//
+// Local times which are skipped at the beginning of daylight saving time activate at the end of the gap.
+// Local times which occur twice at the end of daylight saving time activate only once,
+// unless the hour is a wildcard.
+func (s *spec) Next(after time.Time) time.Time {
+	local := after.In(s.location)
+	year, month, day := local.Date()
+
+	for i := 0; i < maxSearchDays; i++ {
+		date := time.Date(year, month, day+i, 12, 0, 0, 0, s.location)
+		if !s.matchesDay(date) {
+			continue
+		}
+
+		var next time.Time
+		for h := 0; h < 24; h++ {
+			if s.hour&(1<<uint(h)) == 0 {
+				continue
+			}
+			for m := 0; m < 60; m++ {
+				if s.minute&(1<<uint(m)) == 0 {
+					continue
+				}
+				for _, t := range resolve(date.Year(), date.Month(), date.Day(), h, m, s.location, s.hourAny) {
+					if t.After(after) && (next.IsZero() || t.Before(next)) {
+						next = t
+					}
+				}
+			}
+		}
+		if !next.IsZero() {
+			return next
+		}
+	}
+
+	return time.Time{}
+}
+
+func (s *spec) matchesDay(date time.Time) bool {
+	if s.month&(1<<uint(date.Month())) == 0 {
+		return false
+	}
+
+	dom := s.dom&(1<<uint(date.Day())) != 0
+	dow := s.dow&(1<<uint(date.Weekday())) != 0
+	switch {
+	case s.domAny && s.dowAny:
+		return true
+	case s.domAny:
+		return dow
+	case s.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// resolve returns the instants of a local time, which can be none because of a gap or two because of an overlap
+//
+// A local time in a gap is resolved to the end of the gap, of two instants only the first one is returned unless both is set.
+func resolve(year int, month time.Month, day int, hour int, minute int, loc *time.Location, both bool) []time.Time {
+	t := time.Date(year, month, day, hour, minute, 0, 0, loc)
+	before := offset(t.Add(-12 * time.Hour))
+	after := offset(t.Add(12 * time.Hour))
+	if before == after {
+		return []time.Time{t}
+	}
+
+	wall := time.Date(year, month, day, hour, minute, 0, 0, time.UTC).Unix()
+	instants := []time.Time{}
+	for _, o := range []int{before, after} {
+		candidate := time.Unix(wall-int64(o), 0).In(loc)
+		if candidate.Hour() == hour && candidate.Minute() == minute && candidate.Day() == day {
+			instants = append(instants, candidate)
+		}
+	}
+
+	switch {
+	case len(instants) == 2 && !both:
+		return instants[:1]
+	case len(instants) > 0:
+		return instants
+	}
+
+	// find the transition between the candidates of the gap with a binary search
+	lo, hi := wall-int64(after), wall-int64(before)
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		if offset(time.Unix(mid, 0).In(loc)) == before {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+
+	return []time.Time{time.Unix(lo, 0).In(loc)}
+}
+
+func offset(t time.Time) int {
+	_, o := t.Zone()
+	return o
+}
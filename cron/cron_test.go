@@ -0,0 +1,198 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const layout = "2006-01-02 15:04 MST"
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return loc
+}
+
+// activations returns the next n activations of the expression after the local time in Europe/Berlin
+func activations(t *testing.T, expr string, after string, n int) string {
+	berlin := loadLocation(t, "Europe/Berlin")
+	s, err := Parser{Location: berlin}.Parse(expr)
+	if err != nil {
+		t.Fatalf("Could not parse expression '%s': %s", expr, err)
+	}
+	next, err := time.ParseInLocation("2006-01-02 15:04", after, berlin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := []string{}
+	for i := 0; i < n; i++ {
+		next = s.Next(next)
+		result = append(result, next.Format(layout))
+	}
+
+	return strings.Join(result, ", ")
+}
+
+type nextTest struct {
+	expr        string
+	after       string
+	activations []string
+}
+
+func checkNext(t *testing.T, tests []nextTest) {
+	for _, test := range tests {
+		expected := strings.Join(test.activations, ", ")
+		if result := activations(t, test.expr, test.after, len(test.activations)); result != expected {
+			t.Errorf("Expression '%s' after %s activates at %s instead of %s", test.expr, test.after, result, expected)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// 2023-06-02 is a friday
+	checkNext(t, []nextTest{
+		{"30 6 * * mon-fri", "2023-06-02 07:00", []string{"2023-06-05 06:30 CEST", "2023-06-06 06:30 CEST"}},
+		{"*/20 * * * *", "2023-06-02 07:00", []string{"2023-06-02 07:20 CEST", "2023-06-02 07:40 CEST", "2023-06-02 08:00 CEST"}},
+		{"0 8-10/2,22 * * *", "2023-06-02 07:00", []string{"2023-06-02 08:00 CEST", "2023-06-02 10:00 CEST", "2023-06-02 22:00 CEST"}},
+		{"15 12 * jul sat,sun", "2023-06-02 07:00", []string{"2023-07-01 12:15 CEST", "2023-07-02 12:15 CEST"}},
+		// sunday can also be given as 7
+		{"0 9 * * 7", "2023-06-02 07:00", []string{"2023-06-04 09:00 CEST"}},
+		// if both day fields are restricted, a day has to match only one of them
+		{"0 0 1 * 0", "2023-06-02 07:00", []string{"2023-06-04 00:00 CEST", "2023-06-11 00:00 CEST"}},
+		{"0 0 1 * 0", "2023-06-26 07:00", []string{"2023-07-01 00:00 CEST", "2023-07-02 00:00 CEST"}},
+		// the given time itself is not an activation
+		{"0 7 * * *", "2023-06-02 07:00", []string{"2023-06-03 07:00 CEST"}},
+		{"0 7 * * ?", "2023-06-02 07:00", []string{"2023-06-03 07:00 CEST"}},
+	})
+}
+
+func TestNextMacros(t *testing.T) {
+	checkNext(t, []nextTest{
+		{"@yearly", "2023-06-02 07:00", []string{"2024-01-01 00:00 CET", "2025-01-01 00:00 CET"}},
+		{"@annually", "2023-06-02 07:00", []string{"2024-01-01 00:00 CET"}},
+		{"@monthly", "2023-06-02 07:00", []string{"2023-07-01 00:00 CEST", "2023-08-01 00:00 CEST"}},
+		{"@weekly", "2023-06-02 07:00", []string{"2023-06-04 00:00 CEST", "2023-06-11 00:00 CEST"}},
+		{"@daily", "2023-06-02 07:00", []string{"2023-06-03 00:00 CEST"}},
+		{"@midnight", "2023-06-02 07:00", []string{"2023-06-03 00:00 CEST"}},
+		{"@hourly", "2023-06-02 07:30", []string{"2023-06-02 08:00 CEST", "2023-06-02 09:00 CEST"}},
+	})
+}
+
+func TestNextLeapDay(t *testing.T) {
+	checkNext(t, []nextTest{
+		{"0 0 29 2 *", "2023-06-02 07:00", []string{"2024-02-29 00:00 CET", "2028-02-29 00:00 CET"}},
+		{"0 12 29 feb *", "2024-02-29 12:00", []string{"2028-02-29 12:00 CET"}},
+		// the 31st only exists in some months
+		{"0 0 31 * *", "2023-03-31 12:00", []string{"2023-05-31 00:00 CEST", "2023-07-31 00:00 CEST"}},
+	})
+
+	// a day which never exists has no activation
+	s, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Date(2023, 6, 2, 7, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Fatalf("Expression for the 30th of February activates at %s", next)
+	}
+}
+
+func TestNextDaylightSavingTime(t *testing.T) {
+	checkNext(t, []nextTest{
+		// 02:00 to 03:00 is skipped on 2023-03-26, times in the gap activate at its end
+		{"30 2 * * *", "2023-03-25 12:00", []string{"2023-03-26 03:00 CEST", "2023-03-27 02:30 CEST", "2023-03-28 02:30 CEST"}},
+		{"0 2 * * *", "2023-03-25 12:00", []string{"2023-03-26 03:00 CEST", "2023-03-27 02:00 CEST"}},
+		{"30 * * * *", "2023-03-26 01:00", []string{"2023-03-26 01:30 CET", "2023-03-26 03:00 CEST", "2023-03-26 03:30 CEST"}},
+		{"0 */6 * * *", "2023-03-26 00:00", []string{"2023-03-26 06:00 CEST", "2023-03-26 12:00 CEST"}},
+		{"0 1,3 * * *", "2023-03-26 00:00", []string{"2023-03-26 01:00 CET", "2023-03-26 03:00 CEST"}},
+		// 02:00 to 03:00 occurs twice on 2023-10-29, fixed hours activate only once
+		{"30 2 * * *", "2023-10-28 12:00", []string{"2023-10-29 02:30 CEST", "2023-10-30 02:30 CET"}},
+		{"30 2 * * *", "2023-10-29 02:15", []string{"2023-10-30 02:30 CET"}},
+		{"0 3 * * *", "2023-10-29 00:00", []string{"2023-10-29 03:00 CET"}},
+		// a wildcard hour activates in both hours
+		{"30 * * * *", "2023-10-29 01:00", []string{"2023-10-29 01:30 CEST", "2023-10-29 02:30 CEST", "2023-10-29 02:30 CET", "2023-10-29 03:30 CET"}},
+		{"*/30 * * * *", "2023-10-29 01:45", []string{"2023-10-29 02:00 CEST", "2023-10-29 02:30 CEST", "2023-10-29 02:00 CET", "2023-10-29 02:30 CET", "2023-10-29 03:00 CET"}},
+	})
+}
+
+func TestNextTimeZone(t *testing.T) {
+	checkNext(t, []nextTest{
+		{"CRON_TZ=UTC 0 12 * * *", "2023-06-02 07:00", []string{"2023-06-02 12:00 UTC", "2023-06-03 12:00 UTC"}},
+		{"TZ=America/New_York 0 9 * * *", "2023-06-02 07:00", []string{"2023-06-02 09:00 EDT"}},
+		{"CRON_TZ=Asia/Tokyo   @daily", "2023-06-02 07:00", []string{"2023-06-03 00:00 JST"}},
+	})
+
+	// the time zone of the parser is used without prefix
+	s, err := Parser{TimeZone: "America/New_York"}.Parse("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := s.Next(time.Date(2023, 6, 2, 7, 0, 0, 0, time.UTC)).Format(layout); next != "2023-06-02 09:00 EDT" {
+		t.Fatalf("Expression in the time zone of the parser activates at %s", next)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	expressions := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"* * * * mo",
+		"* * * foo *",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"1-2-3 * * * *",
+		"1,,2 * * * *",
+		"@every",
+		"CRON_TZ=UTC",
+		"CRON_TZ=Nowhere/Special * * * * *",
+		"@sunrise",
+	}
+
+	for _, expr := range expressions {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Invalid expression '%s' was parsed", expr)
+		}
+	}
+
+	if _, err := (Parser{TimeZone: "Nowhere/Special"}).Parse("@daily"); err == nil {
+		t.Error("Expression with an invalid time zone of the parser was parsed")
+	}
+}
+
+func TestParseSpec(t *testing.T) {
+	s, err := parseSpec("0,30 9-17/4 */10 jan-mar 1-5", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := spec{
+		minute:   1<<0 | 1<<30,
+		hour:     1<<9 | 1<<13 | 1<<17,
+		dom:      1<<1 | 1<<11 | 1<<21 | 1<<31,
+		month:    1<<1 | 1<<2 | 1<<3,
+		dow:      1<<1 | 1<<2 | 1<<3 | 1<<4 | 1<<5,
+		location: time.UTC,
+	}
+	if *s != expected {
+		t.Fatalf("Expression was parsed as %+v instead of %+v", *s, expected)
+	}
+
+	if s, err = parseSpec("* * * * *", time.UTC); err != nil {
+		t.Fatal(err)
+	}
+	if !s.domAny || !s.dowAny || !s.hourAny || s.dow != 1<<8-1 {
+		t.Fatalf("Wildcards were parsed as %+v", *s)
+	}
+}
@@ -0,0 +1,98 @@
+package cron
+
+import (
+	"math"
+	"time"
+)
+
+// SunEvent is an astronomical event of a day
+type SunEvent int
+
+const (
+	// Sunrise is the moment the upper edge of the sun appears at the horizon
+	Sunrise SunEvent = iota
+	// Sunset is the moment the upper edge of the sun disappears at the horizon
+	Sunset
+)
+
+func (e SunEvent) String() string {
+	if e == Sunset {
+		return "sunset"
+	}
+
+	return "sunrise"
+}
+
+// SunSchedule activates daily relative to sunrise or sunset at the given coordinates
+//
+// Days without the event, e.g. during the polar night, are skipped.
+type SunSchedule struct {
+	Event SunEvent
+	// Offset is added to the time of the event, e.g. -30 minutes to activate before sunset
+	Offset time.Duration
+	// Latitude is positive north and Longitude positive east of Greenwich in degrees
+	Latitude  float64
+	Longitude float64
+	// Location determines the days, it defaults to the local time zone
+	Location *time.Location
+}
+
+// Next returns the first activation after the given time
+func (s SunSchedule) Next(after time.Time) time.Time {
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	year, month, day := after.In(loc).Date()
+	// start a day earlier, an offset can move the activation of the previous day past the given time
+	for i := -1; i < 366; i++ {
+		t, ok := SunTime(s.Event, time.Date(year, month, day+i, 12, 0, 0, 0, loc), s.Latitude, s.Longitude)
+		if !ok {
+			continue
+		}
+		if t = t.Add(s.Offset); t.After(after) {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// SunTime calculates sunrise or sunset of the day at the given coordinates with the equations of the NOAA
+//
+// The result is accurate to about a minute at moderate latitudes.
+// It returns false if the sun doesn't rise or set on that day.
+func SunTime(e SunEvent, date time.Time, latitude float64, longitude float64) (time.Time, bool) {
+	year, month, day := date.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+
+	// fractional year in radians at noon
+	gamma := 2 * math.Pi / daysInYear(year) * float64(date.YearDay()-1)
+	eqTime := 229.18 * (0.000075 + 0.001868*math.Cos(gamma) - 0.032077*math.Sin(gamma) -
+		0.014615*math.Cos(2*gamma) - 0.040849*math.Sin(2*gamma))
+	decl := 0.006918 - 0.399912*math.Cos(gamma) + 0.070257*math.Sin(gamma) -
+		0.006758*math.Cos(2*gamma) + 0.000907*math.Sin(2*gamma) -
+		0.002697*math.Cos(3*gamma) + 0.00148*math.Sin(3*gamma)
+
+	// hour angle with a zenith of 90.833° for the refraction and the radius of the sun
+	lat := latitude * math.Pi / 180
+	cosHA := math.Cos(90.833*math.Pi/180)/(math.Cos(lat)*math.Cos(decl)) - math.Tan(lat)*math.Tan(decl)
+	if cosHA < -1 || cosHA > 1 {
+		return time.Time{}, false
+	}
+	ha := math.Acos(cosHA) * 180 / math.Pi
+
+	minutes := 720 - 4*(longitude+ha) - eqTime
+	if e == Sunset {
+		minutes = 720 - 4*(longitude-ha) - eqTime
+	}
+
+	t := midnight.Add(time.Duration(minutes * float64(time.Minute))).Truncate(time.Second)
+
+	return t.In(date.Location()), true
+}
+
+func daysInYear(year int) float64 {
+	return float64(time.Date(year, 12, 31, 0, 0, 0, 0, time.UTC).YearDay())
+}
@@ -0,0 +1,126 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSunTime(t *testing.T) {
+	// times of the NOAA solar calculator
+	tests := []struct {
+		location  string
+		latitude  float64
+		longitude float64
+		date      string
+		sunrise   string
+		sunset    string
+	}{
+		{"Europe/Berlin", 52.52, 13.405, "2023-06-21", "04:43", "21:33"},
+		{"Europe/Berlin", 52.52, 13.405, "2023-12-21", "08:15", "15:54"},
+		{"America/New_York", 40.7128, -74.006, "2023-12-21", "07:16", "16:32"},
+		{"Australia/Sydney", -33.8688, 151.2093, "2023-06-21", "07:00", "16:54"},
+		{"Asia/Tokyo", 35.6762, 139.6503, "2023-03-21", "05:44", "17:54"},
+	}
+
+	for _, test := range tests {
+		loc := loadLocation(t, test.location)
+		date, err := time.ParseInLocation("2006-01-02 15:04", test.date+" 12:00", loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, e := range []struct {
+			event    SunEvent
+			expected string
+		}{{Sunrise, test.sunrise}, {Sunset, test.sunset}} {
+			expected, err := time.ParseInLocation("2006-01-02 15:04", test.date+" "+e.expected, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, ok := SunTime(e.event, date, test.latitude, test.longitude)
+			if !ok {
+				t.Errorf("No %s in %s on %s", e.event, test.location, test.date)
+				continue
+			}
+			if d := result.Sub(expected); d < -2*time.Minute || d > 2*time.Minute {
+				t.Errorf("%s in %s on %s is at %s instead of %s", e.event, test.location, test.date, result.Format(layout), expected.Format(layout))
+			}
+			if result.Location() != loc {
+				t.Errorf("%s is in time zone %s instead of %s", e.event, result.Location(), loc)
+			}
+		}
+	}
+}
+
+func TestSunTimePolar(t *testing.T) {
+	// polar day and polar night in Tromsø
+	if sunrise, ok := SunTime(Sunrise, time.Date(2023, 6, 21, 12, 0, 0, 0, time.UTC), 69.65, 18.96); ok {
+		t.Errorf("Sun rises at %s during the polar day", sunrise)
+	}
+	if sunset, ok := SunTime(Sunset, time.Date(2023, 12, 21, 12, 0, 0, 0, time.UTC), 69.65, 18.96); ok {
+		t.Errorf("Sun sets at %s during the polar night", sunset)
+	}
+
+	// the schedule skips the days without the event
+	s := SunSchedule{Event: Sunrise, Latitude: 69.65, Longitude: 18.96, Location: time.UTC}
+	next := s.Next(time.Date(2023, 6, 21, 12, 0, 0, 0, time.UTC))
+	if next.IsZero() || next.Month() != time.July {
+		t.Errorf("First sunrise after the polar day is at %s", next)
+	}
+}
+
+func TestSunScheduleNext(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	p := Parser{Location: berlin, Latitude: 52.52, Longitude: 13.405}
+	after := time.Date(2023, 6, 21, 0, 0, 0, 0, berlin)
+
+	tests := []struct {
+		expr     string
+		after    time.Time
+		expected time.Time
+	}{
+		{"@sunrise", after, time.Date(2023, 6, 21, 4, 43, 0, 0, berlin)},
+		{"@sunset", after, time.Date(2023, 6, 21, 21, 33, 0, 0, berlin)},
+		{"@sunset-30m", after, time.Date(2023, 6, 21, 21, 3, 0, 0, berlin)},
+		{"@sunrise+1h30m", after, time.Date(2023, 6, 21, 6, 13, 0, 0, berlin)},
+		// the activation of the previous day can be after the given time
+		{"@sunset+3h", time.Date(2023, 6, 21, 23, 0, 0, 0, berlin), time.Date(2023, 6, 22, 0, 33, 0, 0, berlin)},
+		// after today's sunrise the next one is tomorrow
+		{"@sunrise", time.Date(2023, 6, 21, 12, 0, 0, 0, berlin), time.Date(2023, 6, 22, 4, 43, 0, 0, berlin)},
+		{"CRON_TZ=UTC @sunrise", after, time.Date(2023, 6, 21, 2, 43, 0, 0, time.UTC)},
+	}
+
+	for _, test := range tests {
+		s, err := p.Parse(test.expr)
+		if err != nil {
+			t.Fatalf("Could not parse expression '%s': %s", test.expr, err)
+		}
+		next := s.Next(test.after)
+		if d := next.Sub(test.expected); d < -2*time.Minute || d > 2*time.Minute {
+			t.Errorf("Expression '%s' after %s activates at %s instead of about %s", test.expr, test.after.Format(layout), next.Format(layout), test.expected.Format(layout))
+		}
+	}
+
+	// consecutive activations are about a day apart
+	s, err := p.Parse("@sunset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := s.Next(after)
+	if d := s.Next(first).Sub(first); d < 23*time.Hour || d > 25*time.Hour {
+		t.Errorf("Consecutive sunsets are %s apart", d)
+	}
+}
+
+func TestParseSunErrors(t *testing.T) {
+	p := Parser{Location: time.UTC, Latitude: 52.52, Longitude: 13.405}
+	for _, expr := range []string{"@sunrise15m", "@sunset+", "@sunset+15", "@sunrise-x", "@sundown"} {
+		if _, err := p.Parse(expr); err == nil {
+			t.Errorf("Invalid expression '%s' was parsed", expr)
+		}
+	}
+
+	if _, err := (Parser{Location: time.UTC}).Parse("@sunset"); err == nil {
+		t.Error("Expression without coordinates was parsed")
+	}
+}
@@ -1,11 +1,11 @@
 package mqtthelper
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"strconv"
-	"sync"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
@@ -20,9 +20,6 @@ type opts struct {
 	ConfigFile string `short:"c" long:"config" default:"config.yaml" description:"Path to config file to use"`
 }
 
-var delays = make(map[string]*time.Timer)
-var delayMutex = &sync.Mutex{}
-
 func ParseConfigOption(c interface{}) error {
 	opts := opts{}
 	if _, err := flags.Parse(&opts); err != nil {
@@ -50,75 +47,104 @@ func LoadConfig(path string, c interface{}) error {
 }
 
 func NewClientLogin(uri string, user string, password string, h OnConnectHandler) (mqtt.Client, error) {
-        co := getClientOptions(uri, h, true)
-        co.SetUsername(user) 
-        co.SetPassword(password)
-        c := mqtt.NewClient(co)
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
-	}
+	return NewClientLoginContext(context.Background(), uri, user, password, h)
+}
 
-	return c, nil
+func NewClientLoginContext(ctx context.Context, uri string, user string, password string, h OnConnectHandler) (mqtt.Client, error) {
+	return NewClientWithOptionsContext(ctx, ClientOptions{URI: uri, User: user, Password: password, Parallel: true}, h)
 }
 
 func NewClient(uri string, h OnConnectHandler) (mqtt.Client, error) {
-	c := mqtt.NewClient(getClientOptions(uri, h, false))
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
-	}
+	return NewClientContext(context.Background(), uri, h)
+}
 
-	return c, nil
+func NewClientContext(ctx context.Context, uri string, h OnConnectHandler) (mqtt.Client, error) {
+	return NewClientWithOptionsContext(ctx, ClientOptions{URI: uri}, h)
 }
 
 func NewClientParallel(uri string, h OnConnectHandler) (mqtt.Client, error) {
-	c := mqtt.NewClient(getClientOptions(uri, h, true))
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
-	}
+	return NewClientParallelContext(context.Background(), uri, h)
+}
 
-	return c, nil
+func NewClientParallelContext(ctx context.Context, uri string, h OnConnectHandler) (mqtt.Client, error) {
+	return NewClientWithOptionsContext(ctx, ClientOptions{URI: uri, Parallel: true}, h)
 }
 
 func NewClientParallelLogin(uri string, user string, password string, h OnConnectHandler) (mqtt.Client, error) {
-        co := getClientOptions(uri, h, true)
-        co.SetUsername(user) 
-        co.SetPassword(password)
-        c := mqtt.NewClient(co)
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
-	}
+	return NewClientParallelLoginContext(context.Background(), uri, user, password, h)
+}
 
-	return c, nil
+func NewClientParallelLoginContext(ctx context.Context, uri string, user string, password string, h OnConnectHandler) (mqtt.Client, error) {
+	return NewClientWithOptionsContext(ctx, ClientOptions{URI: uri, User: user, Password: password, Parallel: true}, h)
 }
 
 func NewMessageChannel() MessageChannel {
 	return make(MessageChannel)
 }
 
+// SubscribeOptions configure a subscription
+type SubscribeOptions struct {
+	QoS byte `yaml:"qos"`
+	// NoRetained drops retained messages, so stale messages aren't replayed when subscribing
+	NoRetained bool `yaml:"noretained"`
+}
+
 func Subscribe(c mqtt.Client, topic string, ch MessageChannel) error {
+	return SubscribeContext(context.Background(), c, topic, ch)
+}
+
+func SubscribeContext(ctx context.Context, c mqtt.Client, topic string, ch MessageChannel) error {
+	return SubscribeWithOptionsContext(ctx, c, topic, ch, SubscribeOptions{})
+}
+
+// SubscribeWithOptions passes the messages of the topic to the channel
+func SubscribeWithOptions(c mqtt.Client, topic string, ch MessageChannel, o SubscribeOptions) error {
+	return SubscribeWithOptionsContext(context.Background(), c, topic, ch, o)
+}
+
+// SubscribeWithOptionsContext passes the messages of the topic to the channel, aborting when the context is done
+func SubscribeWithOptionsContext(ctx context.Context, c mqtt.Client, topic string, ch MessageChannel, o SubscribeOptions) error {
 	h := func(c mqtt.Client, msg mqtt.Message) {
 		ch <- msg
 	}
 
-	return SubscribeHandler(c, topic, h)
+	return SubscribeHandlerWithOptionsContext(ctx, c, topic, h, o)
 }
 
 func SubscribeHandler(c mqtt.Client, topic string, handler mqtt.MessageHandler) error {
+	return SubscribeHandlerContext(context.Background(), c, topic, handler)
+}
+
+func SubscribeHandlerContext(ctx context.Context, c mqtt.Client, topic string, handler mqtt.MessageHandler) error {
+	return SubscribeHandlerWithOptionsContext(ctx, c, topic, handler, SubscribeOptions{})
+}
+
+// SubscribeHandlerWithOptions calls the handler for the messages of the topic
+func SubscribeHandlerWithOptions(c mqtt.Client, topic string, handler mqtt.MessageHandler, o SubscribeOptions) error {
+	return SubscribeHandlerWithOptionsContext(context.Background(), c, topic, handler, o)
+}
+
+// SubscribeHandlerWithOptionsContext calls the handler for the messages of the topic, aborting when the context is done
+func SubscribeHandlerWithOptionsContext(ctx context.Context, c mqtt.Client, topic string, handler mqtt.MessageHandler, o SubscribeOptions) error {
 	h := func(c mqtt.Client, msg mqtt.Message) {
+		if o.NoRetained && msg.Retained() {
+			log.Printf("Dropped retained message '%s' through topic %s", msg.Payload(), msg.Topic())
+			return
+		}
 		log.Printf("Received message '%s' through topic %s (retained: %s)", msg.Payload(), msg.Topic(), strconv.FormatBool(msg.Retained()))
 		handler(c, msg)
 	}
 
-	if token := c.Subscribe(topic, 0, h); token.Wait() && token.Error() != nil {
-		return token.Error()
-	}
-	return nil
+	return WaitToken(ctx, c.Subscribe(topic, o.QoS, h))
 }
 
 func PublishMessage(c mqtt.Client, topic string, qos byte, retained bool, payload string) bool {
-	token := c.Publish(topic, qos, retained, payload)
-	if token.Wait() && token.Error() != nil {
-		log.Printf("Could not publish message '%s' to topic %s: %s", payload, topic, token.Error())
+	return PublishMessageContext(context.Background(), c, topic, qos, retained, payload)
+}
+
+func PublishMessageContext(ctx context.Context, c mqtt.Client, topic string, qos byte, retained bool, payload string) bool {
+	if err := WaitToken(ctx, c.Publish(topic, qos, retained, payload)); err != nil {
+		log.Printf("Could not publish message '%s' to topic %s: %s", payload, topic, err)
 		return false
 	}
 	log.Printf("Published message '%s' to topic %s (retained: %s)", payload, topic, strconv.FormatBool(retained))
@@ -126,46 +152,41 @@ func PublishMessage(c mqtt.Client, topic string, qos byte, retained bool, payloa
 }
 
 func PublishCustomMessage(c mqtt.Client, topic string, qos byte, retained bool, payload interface{}) bool {
+	return PublishCustomMessageContext(context.Background(), c, topic, qos, retained, payload)
+}
+
+func PublishCustomMessageContext(ctx context.Context, c mqtt.Client, topic string, qos byte, retained bool, payload interface{}) bool {
 	p, err := json.Marshal(payload)
 	if err != nil {
 		log.Printf("Error while marshalling message payload for topic %s: %s", topic, err)
 		return false
 	}
 
-	return PublishMessage(c, topic, qos, retained, string(p))
+	return PublishMessageContext(ctx, c, topic, qos, retained, string(p))
 }
 
-func DelayMessage(c mqtt.Client, id string, topic string, qos byte, retained bool, payload string, delay time.Duration) {
-	if _, ok := delays[id]; ok {
-		return
-	}
+// WaitToken waits for the token to complete and returns its error, aborting when the context is done
+func WaitToken(ctx context.Context, token mqtt.Token) error {
+	done := make(chan struct{})
+	go func() {
+		token.Wait()
+		close(done)
+	}()
 
-	f := func() {
-		PublishMessage(c, topic, qos, retained, payload)
-		CancelDelayedMessage(id)
+	select {
+	case <-done:
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	log.Printf("Delay message with id %s on topic %s", id, topic)
-	delayMutex.Lock()
-	delays[id] = time.AfterFunc(delay, f)
-	delayMutex.Unlock()
-
-	return
+func DelayMessage(c mqtt.Client, id string, topic string, qos byte, retained bool, payload string, delay time.Duration) {
+	defaultScheduler.DelayMessage(c, id, topic, qos, retained, payload, delay)
 }
 
 func CancelDelayedMessage(id string) {
-	newDelays := make(map[string]*time.Timer)
-
-	delayMutex.Lock()
-	for delayId, t := range delays {
-		if delayId == id {
-			t.Stop()
-		} else {
-			newDelays[id] = t
-		}
-	}
-	delays = newDelays
-	delayMutex.Unlock()
+	defaultScheduler.Cancel(id)
 }
 
 func HandleError(err error, msgPrefix string) {
@@ -180,21 +201,22 @@ func HandleFatalError(err error) {
 	}
 }
 
-func getClientOptions(uri string, h OnConnectHandler, parallel bool) *mqtt.ClientOptions {
-	ops := mqtt.NewClientOptions().AddBroker(uri)
-
-	if parallel {
-		ops.SetOrderMatters(false)
+// connect connects the client, if the context is done first the connection is closed as soon as the attempt finished
+func connect(ctx context.Context, co *mqtt.ClientOptions) (mqtt.Client, error) {
+	c := mqtt.NewClient(co)
+	token := c.Connect()
+	if err := WaitToken(ctx, token); err != nil {
+		if ctx.Err() != nil {
+			// disconnecting while the connection is established has no effect, so nobody would own the client afterwards
+			go func() {
+				token.Wait()
+				if c.IsConnected() {
+					c.Disconnect(0)
+				}
+			}()
+		}
+		return nil, err
 	}
 
-	ops.SetConnectionLostHandler(func(c mqtt.Client, err error) {
-		log.Printf("Connection to MQTT lost: %s", err)
-	})
-
-	ops.SetOnConnectHandler(func(c mqtt.Client) {
-		log.Printf("Connected to MQTT at %s", uri)
-		h(c)
-	})
-
-	return ops
+	return c, nil
 }
@@ -0,0 +1,130 @@
+package mqtthelper
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// ClientOptions configures the connection to MQTT, it can be part of the config loaded by LoadConfig
+type ClientOptions struct {
+	URI      string `yaml:"uri"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	ClientID string `yaml:"clientid"`
+	// KeepAlive defaults to 30 seconds
+	KeepAlive time.Duration `yaml:"keepalive"`
+	// CleanSession defaults to true
+	CleanSession *bool `yaml:"cleansession"`
+	// Parallel handles received messages in parallel instead of in order
+	Parallel bool       `yaml:"parallel"`
+	TLS      TLSOptions `yaml:"tls"`
+}
+
+// TLSOptions configures TLS for URIs with the scheme ssl, tls or tcps
+type TLSOptions struct {
+	// CAFile contains the PEM encoded certificates to verify the server, defaults to the system's certificates
+	CAFile string `yaml:"cafile"`
+	// CertFile and KeyFile contain the PEM encoded client certificate and its key
+	CertFile           string `yaml:"certfile"`
+	KeyFile            string `yaml:"keyfile"`
+	ServerName         string `yaml:"servername"`
+	InsecureSkipVerify bool   `yaml:"insecureskipverify"`
+}
+
+// NewClientWithOptions connects to MQTT with the given options
+func NewClientWithOptions(o ClientOptions, h OnConnectHandler) (mqtt.Client, error) {
+	return NewClientWithOptionsContext(context.Background(), o, h)
+}
+
+// NewClientWithOptionsContext connects to MQTT with the given options, aborting when the context is done
+func NewClientWithOptionsContext(ctx context.Context, o ClientOptions, h OnConnectHandler) (mqtt.Client, error) {
+	co, err := o.MQTTOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	co.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		log.Printf("Connection to MQTT lost: %s", err)
+	})
+
+	co.SetOnConnectHandler(func(c mqtt.Client) {
+		log.Printf("Connected to MQTT at %s", o.URI)
+		if h != nil {
+			h(c)
+		}
+	})
+
+	return connect(ctx, co)
+}
+
+// MQTTOptions converts the options to the options of the MQTT client
+func (o ClientOptions) MQTTOptions() (*mqtt.ClientOptions, error) {
+	co := mqtt.NewClientOptions().AddBroker(o.URI)
+
+	if o.User != "" {
+		co.SetUsername(o.User)
+		co.SetPassword(o.Password)
+	}
+	if o.ClientID != "" {
+		co.SetClientID(o.ClientID)
+	}
+	if o.KeepAlive > 0 {
+		co.SetKeepAlive(o.KeepAlive)
+	}
+	if o.CleanSession != nil {
+		co.SetCleanSession(*o.CleanSession)
+	}
+	if o.Parallel {
+		co.SetOrderMatters(false)
+	}
+
+	tlsConfig, err := o.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		co.SetTLSConfig(tlsConfig)
+	}
+
+	return co, nil
+}
+
+// Config creates the TLS config, it returns nil if no option is set
+func (o TLSOptions) Config() (*tls.Config, error) {
+	if o == (TLSOptions{}) {
+		return nil, nil
+	}
+
+	c := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read CA file: %s", err)
+		}
+		c.RootCAs = x509.NewCertPool()
+		if !c.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s doesn't contain any certificate", o.CAFile)
+		}
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not load client certificate: %s", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,451 @@
+package mqtthelper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/frado1/libs/clock"
+	"github.com/frado1/libs/cron"
+)
+
+// ShutdownMode defines what happens to pending jobs when a scheduler is shut down
+type ShutdownMode int
+
+const (
+	// DropPending cancels all pending jobs
+	DropPending ShutdownMode = iota
+	// FlushPending runs all pending jobs immediately
+	FlushPending
+)
+
+// MissedJobPolicy defines what happens to restored jobs which were due while the scheduler wasn't running
+type MissedJobPolicy int
+
+const (
+	// FireMissed runs missed jobs immediately
+	FireMissed MissedJobPolicy = iota
+	// DropMissed discards missed jobs
+	DropMissed
+)
+
+// Job describes a pending job of a scheduler
+type Job struct {
+	ID  string
+	Due time.Time
+}
+
+// Scheduler runs jobs after a delay, every job is identified by an ID
+type Scheduler struct {
+	clock   clock.Clock
+	jobs    map[string]*scheduledJob
+	closed  bool
+	running *sync.WaitGroup
+	mutex   *sync.Mutex
+	path    string
+	// restored is set once the persisted messages were restored, they would be overwritten otherwise
+	restored bool
+}
+
+type scheduledJob struct {
+	id    string
+	f     func()
+	due   time.Time
+	timer clock.Timer
+	// generation is increased whenever the job is rearmed, so a timer which already fired can detect that it is outdated
+	generation int
+	// message is set for delayed messages, only those are persisted
+	message *delayedMessage
+	// schedule is set for recurring jobs, which are rearmed for the next activation when they run
+	schedule cron.Schedule
+}
+
+type delayedMessage struct {
+	ID       string    `json:"id"`
+	Topic    string    `json:"topic"`
+	QoS      byte      `json:"qos"`
+	Retained bool      `json:"retained"`
+	Payload  string    `json:"payload"`
+	Due      time.Time `json:"due"`
+}
+
+// SchedulerOption configures a scheduler
+type SchedulerOption func(*Scheduler)
+
+// WithClock replaces the system clock of the scheduler, e.g. with a fake clock in tests
+func WithClock(c clock.Clock) SchedulerOption {
+	return func(s *Scheduler) {
+		s.clock = c
+	}
+}
+
+// WithPersistence saves pending delayed messages with their due time to the file, so they can be restored after a restart
+//
+// Only messages scheduled with DelayMessage are persisted, other jobs are lost on a restart.
+// Messages which were still pending when the scheduler was shut down with DropPending remain in the file.
+// The file is only written after the messages were restored with Restore.
+func WithPersistence(path string) SchedulerOption {
+	return func(s *Scheduler) {
+		s.path = path
+	}
+}
+
+// NewScheduler creates a new scheduler
+func NewScheduler(options ...SchedulerOption) *Scheduler {
+	s := &Scheduler{
+		clock:   clock.System,
+		jobs:    map[string]*scheduledJob{},
+		running: &sync.WaitGroup{},
+		mutex:   &sync.Mutex{},
+	}
+	for _, option := range options {
+		option(s)
+	}
+
+	return s
+}
+
+var defaultScheduler = NewScheduler()
+
+// Schedule runs the function after the delay, unless a job with the same ID is already pending
+//
+// It returns whether the job was scheduled.
+func (s *Scheduler) Schedule(id string, delay time.Duration, f func()) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.schedule(&scheduledJob{id: id, f: f}, delay)
+}
+
+// Reschedule restarts the delay of a pending job and returns whether the job was pending
+//
+// A recurring job runs after the delay and continues with the activations of its schedule afterwards.
+func (s *Scheduler) Reschedule(id string, delay time.Duration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+	j.timer.Stop()
+	s.arm(j, delay)
+	s.persist()
+
+	return true
+}
+
+// Debounce runs the function after the delay, replacing a pending job with the same ID and restarting its delay
+//
+// Only the last of several calls within the delay runs. A recurring job with the same ID is replaced by a single run.
+func (s *Scheduler) Debounce(id string, delay time.Duration, f func()) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	j, ok := s.jobs[id]
+	if ok {
+		j.timer.Stop()
+	} else {
+		j = &scheduledJob{id: id}
+		s.jobs[id] = j
+	}
+	j.f = f
+	j.message = nil
+	j.schedule = nil
+	s.arm(j, delay)
+	s.persist()
+}
+
+// Cancel removes a pending job and returns whether it was pending
+func (s *Scheduler) Cancel(id string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return false
+	}
+	j.timer.Stop()
+	delete(s.jobs, id)
+	s.persist()
+
+	return true
+}
+
+// Pending returns all pending jobs ordered by their due time
+func (s *Scheduler) Pending() []Job {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	jobs := jobsByDue{}
+	for _, j := range s.jobs {
+		jobs = append(jobs, Job{ID: j.id, Due: j.due})
+	}
+	sort.Sort(jobs)
+
+	return jobs
+}
+
+// Shutdown stops the scheduler and waits for running jobs, pending jobs are dropped or run depending on the mode
+//
+// Jobs can't be scheduled anymore after the shutdown.
+func (s *Scheduler) Shutdown(mode ShutdownMode) {
+	s.mutex.Lock()
+	s.closed = true
+	jobs := jobsByDue{}
+	pending := map[string]*scheduledJob{}
+	for id, j := range s.jobs {
+		j.timer.Stop()
+		if j.schedule == nil {
+			jobs = append(jobs, Job{ID: id, Due: j.due})
+			pending[id] = j
+		}
+	}
+	s.jobs = map[string]*scheduledJob{}
+	s.mutex.Unlock()
+
+	if mode == FlushPending {
+		sort.Sort(jobs)
+		for _, job := range jobs {
+			pending[job.ID].f()
+		}
+
+		s.mutex.Lock()
+		s.persist()
+		s.mutex.Unlock()
+	}
+
+	s.running.Wait()
+}
+
+// DelayMessage publishes the message after the delay, unless a message with the same ID is already pending
+func (s *Scheduler) DelayMessage(c mqtt.Client, id string, topic string, qos byte, retained bool, payload string, delay time.Duration) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	m := &delayedMessage{ID: id, Topic: topic, QoS: qos, Retained: retained, Payload: payload}
+	if !s.schedule(newMessageJob(c, m), delay) {
+		return false
+	}
+	log.Printf("Delay message with id %s on topic %s", id, topic)
+
+	return true
+}
+
+// Every runs the function at every activation of the schedule until it is cancelled, unless a job with the same ID is already pending
+//
+// Recurring jobs are never persisted and not run by a shutdown with FlushPending.
+// Activations which were missed, e.g. because the system was suspended, are skipped.
+func (s *Scheduler) Every(id string, schedule cron.Schedule, f func()) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock.Now()
+	next := schedule.Next(now)
+	if next.IsZero() {
+		return false
+	}
+
+	return s.schedule(&scheduledJob{id: id, f: f, schedule: schedule}, next.Sub(now))
+}
+
+// PublishEvery publishes the message at every activation of the schedule, see Every
+func (s *Scheduler) PublishEvery(c mqtt.Client, id string, schedule cron.Schedule, topic string, qos byte, retained bool, payload string) bool {
+	scheduled := s.Every(id, schedule, func() {
+		PublishMessage(c, topic, qos, retained, payload)
+	})
+	if scheduled {
+		log.Printf("Scheduled message with id %s on topic %s", id, topic)
+	}
+
+	return scheduled
+}
+
+// Restore schedules the delayed messages of the persistence file again, they are published with the given client
+//
+// Messages which were due while the scheduler wasn't running are published immediately or dropped depending on the policy.
+// A message is dropped if a job with the same ID was scheduled before restoring, so it should be called before scheduling any job.
+func (s *Scheduler) Restore(c mqtt.Client, policy MissedJobPolicy) error {
+	if s.path == "" {
+		return errors.New("Scheduler has no persistence configured")
+	}
+
+	messages := []*delayedMessage{}
+	b, err := ioutil.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Could not read delayed messages: %s", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return fmt.Errorf("Could not parse delayed messages: %s", err)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// the file would be overwritten without the messages
+	if s.closed {
+		return errors.New("Scheduler was already shut down")
+	}
+
+	now := s.clock.Now()
+	for _, m := range messages {
+		delay := m.Due.Sub(now)
+		if delay < 0 {
+			if policy == DropMissed {
+				log.Printf("Dropping missed message with id %s on topic %s, it was due at %s", m.ID, m.Topic, m.Due.Format(time.RFC3339))
+				continue
+			}
+			delay = 0
+		}
+		if s.schedule(newMessageJob(c, m), delay) {
+			log.Printf("Restored delayed message with id %s on topic %s", m.ID, m.Topic)
+		} else {
+			log.Printf("Dropping restored message with id %s on topic %s, a job with the same id is already pending", m.ID, m.Topic)
+		}
+	}
+	s.restored = true
+	s.persist()
+
+	return nil
+}
+
+func newMessageJob(c mqtt.Client, m *delayedMessage) *scheduledJob {
+	return &scheduledJob{
+		id: m.ID,
+		f: func() {
+			PublishMessage(c, m.Topic, m.QoS, m.Retained, m.Payload)
+		},
+		message: m,
+	}
+}
+
+// schedule adds the job unless a job with the same ID is pending, the mutex has to be locked
+func (s *Scheduler) schedule(j *scheduledJob, delay time.Duration) bool {
+	if _, ok := s.jobs[j.id]; ok || s.closed {
+		return false
+	}
+
+	s.jobs[j.id] = j
+	s.arm(j, delay)
+	s.persist()
+
+	return true
+}
+
+// arm starts the timer of the job, the mutex has to be locked
+func (s *Scheduler) arm(j *scheduledJob, delay time.Duration) {
+	j.generation++
+	generation := j.generation
+	j.due = s.clock.Now().Add(delay)
+	if j.message != nil {
+		j.message.Due = j.due
+	}
+	j.timer = s.clock.AfterFunc(delay, func() {
+		s.fire(j, generation)
+	})
+}
+
+func (s *Scheduler) fire(j *scheduledJob, generation int) {
+	s.mutex.Lock()
+	if s.jobs[j.id] != j || j.generation != generation {
+		s.mutex.Unlock()
+		return
+	}
+	if j.schedule != nil {
+		s.rearm(j)
+	} else {
+		delete(s.jobs, j.id)
+	}
+	f := j.f
+	s.running.Add(1)
+	s.mutex.Unlock()
+
+	defer s.running.Done()
+	f()
+
+	// persist only after running, so a message is rather published twice than lost
+	if j.message != nil {
+		s.mutex.Lock()
+		s.persist()
+		s.mutex.Unlock()
+	}
+}
+
+// rearm arms a recurring job for its next activation after the current one, the mutex has to be locked
+func (s *Scheduler) rearm(j *scheduledJob) {
+	now := s.clock.Now()
+	next := j.schedule.Next(j.due)
+	if !next.After(now) {
+		next = j.schedule.Next(now)
+	}
+	if next.IsZero() {
+		delete(s.jobs, j.id)
+		return
+	}
+
+	s.arm(j, next.Sub(now))
+}
+
+// persist writes the pending delayed messages to the persistence file, the mutex has to be locked
+func (s *Scheduler) persist() {
+	if s.path == "" || !s.restored {
+		return
+	}
+
+	messages := []*delayedMessage{}
+	for _, j := range s.jobs {
+		if j.message != nil {
+			messages = append(messages, j.message)
+		}
+	}
+	b, err := json.Marshal(messages)
+	if err != nil {
+		log.Printf("Could not marshal delayed messages: %s", err)
+		return
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := writeFileSync(tmpPath, b); err != nil {
+		log.Printf("Could not persist delayed messages: %s", err)
+		return
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		log.Printf("Could not persist delayed messages: %s", err)
+	}
+}
+
+func writeFileSync(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+type jobsByDue []Job
+
+func (j jobsByDue) Len() int           { return len(j) }
+func (j jobsByDue) Swap(a, b int)      { j[a], j[b] = j[b], j[a] }
+func (j jobsByDue) Less(a, b int) bool { return j[a].Due.Before(j[b].Due) }
@@ -0,0 +1,456 @@
+package mqtthelper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/frado1/libs/clock"
+	"github.com/frado1/libs/cron"
+)
+
+// recorder collects the IDs of the jobs which ran, the fake clock runs them synchronously
+type recorder struct {
+	fired []string
+}
+
+func (r *recorder) job(id string) func() {
+	return func() {
+		r.fired = append(r.fired, id)
+	}
+}
+
+func (r *recorder) expect(t *testing.T, ids ...string) {
+	if strings.Join(r.fired, ",") != strings.Join(ids, ",") {
+		t.Fatalf("Jobs %v ran instead of %v", r.fired, ids)
+	}
+}
+
+func pendingIDs(s *Scheduler) string {
+	ids := []string{}
+	for _, j := range s.Pending() {
+		ids = append(ids, j.ID)
+	}
+
+	return strings.Join(ids, ",")
+}
+
+func TestSchedule(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	if !s.Schedule("a", time.Second, r.job("a")) {
+		t.Fatal("Job was not scheduled")
+	}
+	if s.Schedule("a", time.Minute, r.job("other")) {
+		t.Fatal("Job with the ID of a pending job was scheduled")
+	}
+
+	c.Advance(999 * time.Millisecond)
+	r.expect(t)
+	c.Advance(time.Millisecond)
+	r.expect(t, "a")
+
+	// the ID can be used again once the job ran
+	if !s.Schedule("a", time.Second, r.job("a")) {
+		t.Fatal("Job was not scheduled again")
+	}
+	c.Advance(time.Second)
+	r.expect(t, "a", "a")
+}
+
+func TestCancel(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	s.Schedule("a", time.Second, r.job("a"))
+	s.Schedule("b", 2*time.Second, r.job("b"))
+	s.Schedule("c", 3*time.Second, r.job("c"))
+
+	if !s.Cancel("b") {
+		t.Fatal("Pending job was not cancelled")
+	}
+	if s.Cancel("b") {
+		t.Fatal("Cancelled job was cancelled again")
+	}
+	if ids := pendingIDs(s); ids != "a,c" {
+		t.Fatalf("Jobs %s are pending after cancelling b", ids)
+	}
+
+	c.Advance(3 * time.Second)
+	r.expect(t, "a", "c")
+}
+
+func TestReschedule(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := clock.NewFake(start)
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	if s.Reschedule("a", time.Second) {
+		t.Fatal("Job which is not pending was rescheduled")
+	}
+
+	s.Schedule("a", time.Second, r.job("a"))
+	c.Advance(500 * time.Millisecond)
+	if !s.Reschedule("a", time.Second) {
+		t.Fatal("Pending job was not rescheduled")
+	}
+	if p := s.Pending(); len(p) != 1 || !p[0].Due.Equal(start.Add(1500*time.Millisecond)) {
+		t.Fatalf("Unexpected pending jobs %v after rescheduling", p)
+	}
+
+	c.Advance(900 * time.Millisecond)
+	r.expect(t)
+	c.Advance(100 * time.Millisecond)
+	r.expect(t, "a")
+}
+
+func TestDebounce(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		s.Debounce("d", time.Second, r.job(id))
+		c.Advance(500 * time.Millisecond)
+	}
+	r.expect(t)
+
+	// only the last function runs
+	c.Advance(500 * time.Millisecond)
+	r.expect(t, "5")
+	c.Advance(time.Hour)
+	r.expect(t, "5")
+
+	// debouncing replaces a job which was scheduled before
+	s.Schedule("e", time.Second, r.job("scheduled"))
+	s.Debounce("e", 2*time.Second, r.job("debounced"))
+	c.Advance(2 * time.Second)
+	r.expect(t, "5", "debounced")
+}
+
+func TestPending(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := clock.NewFake(start)
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	s.Schedule("c", 3*time.Second, r.job("c"))
+	s.Schedule("a", time.Second, r.job("a"))
+	s.Schedule("b", 2*time.Second, r.job("b"))
+
+	p := s.Pending()
+	expected := []Job{{ID: "a", Due: start.Add(time.Second)}, {ID: "b", Due: start.Add(2 * time.Second)}, {ID: "c", Due: start.Add(3 * time.Second)}}
+	if len(p) != len(expected) {
+		t.Fatalf("Unexpected pending jobs %v", p)
+	}
+	for i := range expected {
+		if p[i].ID != expected[i].ID || !p[i].Due.Equal(expected[i].Due) {
+			t.Fatalf("Unexpected pending jobs %v", p)
+		}
+	}
+
+	c.Advance(time.Second)
+	if ids := pendingIDs(s); ids != "b,c" {
+		t.Fatalf("Jobs %s are pending after the first one ran", ids)
+	}
+}
+
+func TestShutdownFlushPending(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	s.Schedule("hour", time.Hour, r.job("hour"))
+	s.Schedule("minute", time.Minute, r.job("minute"))
+	s.Schedule("second", time.Second, r.job("second"))
+	s.Cancel("second")
+
+	// pending jobs run immediately in the order of their due time
+	s.Shutdown(FlushPending)
+	r.expect(t, "minute", "hour")
+
+	if s.Schedule("late", 0, r.job("late")) {
+		t.Fatal("Job was scheduled after the shutdown")
+	}
+	s.Debounce("late", 0, r.job("late"))
+	c.Advance(time.Hour)
+	r.expect(t, "minute", "hour")
+	if ids := pendingIDs(s); ids != "" {
+		t.Fatalf("Jobs %s are pending after the shutdown", ids)
+	}
+}
+
+func TestShutdownDropPending(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	s.Schedule("a", time.Second, r.job("a"))
+	s.Debounce("b", time.Second, r.job("b"))
+	s.Shutdown(DropPending)
+
+	c.Advance(time.Hour)
+	r.expect(t)
+	if c.Pending() != 0 {
+		t.Fatalf("%d timers are still pending after the shutdown", c.Pending())
+	}
+}
+
+func TestShutdownWaitsForRunningJobs(t *testing.T) {
+	s := NewScheduler()
+
+	started := make(chan struct{})
+	finished := make(chan struct{})
+	s.Schedule("slow", 0, func() {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+	})
+	<-started
+
+	s.Shutdown(DropPending)
+	select {
+	case <-finished:
+	default:
+		t.Fatal("Shutdown returned before the running job finished")
+	}
+}
+
+func everyTenMinutes(t *testing.T) cron.Schedule {
+	schedule, err := cron.Parser{Location: time.UTC}.Parse("*/10 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return schedule
+}
+
+func TestEvery(t *testing.T) {
+	start := time.Date(2023, 6, 2, 7, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	if !s.Every("poll", everyTenMinutes(t), r.job("poll")) {
+		t.Fatal("Recurring job was not scheduled")
+	}
+	c.Advance(35 * time.Minute)
+	r.expect(t, "poll", "poll", "poll")
+	if p := s.Pending(); len(p) != 1 || !p[0].Due.Equal(start.Add(40*time.Minute)) {
+		t.Fatalf("Unexpected pending jobs %v", p)
+	}
+
+	// recurring jobs don't run on a shutdown
+	s.Shutdown(FlushPending)
+	r.expect(t, "poll", "poll", "poll")
+}
+
+func TestDebounceRecurringJob(t *testing.T) {
+	c := clock.NewFake(time.Date(2023, 6, 2, 7, 0, 0, 0, time.UTC))
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	s.Every("poll", everyTenMinutes(t), r.job("poll"))
+	s.Debounce("poll", time.Minute, r.job("debounced"))
+	c.Advance(time.Hour)
+	r.expect(t, "debounced")
+	if ids := pendingIDs(s); ids != "" {
+		t.Fatalf("Jobs %s are pending after the debounced job ran", ids)
+	}
+}
+
+func TestRescheduleRecurringJob(t *testing.T) {
+	start := time.Date(2023, 6, 2, 7, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	s := NewScheduler(WithClock(c))
+	r := &recorder{}
+
+	s.Every("poll", everyTenMinutes(t), r.job("poll"))
+	s.Reschedule("poll", 15*time.Minute)
+	c.Advance(14 * time.Minute)
+	r.expect(t)
+	c.Advance(time.Minute)
+	r.expect(t, "poll")
+
+	// the schedule continues after the rescheduled run
+	if p := s.Pending(); len(p) != 1 || !p[0].Due.Equal(start.Add(20*time.Minute)) {
+		t.Fatalf("Unexpected pending jobs %v", p)
+	}
+}
+
+func tempPersistence(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "messages.json"), func() { os.RemoveAll(dir) }
+}
+
+// persistedIDs returns the IDs of the messages in the persistence file ordered by their due time
+func persistedIDs(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []*delayedMessage{}
+	if err := json.Unmarshal(b, &messages); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := jobsByDue{}
+	for _, m := range messages {
+		jobs = append(jobs, Job{ID: m.ID, Due: m.Due})
+	}
+	sort.Sort(jobs)
+	ids := []string{}
+	for _, j := range jobs {
+		ids = append(ids, j.ID)
+	}
+
+	return strings.Join(ids, ",")
+}
+
+func TestPersistence(t *testing.T) {
+	path, cleanup := tempPersistence(t)
+	defer cleanup()
+	start := time.Unix(1000, 0)
+	c := clock.NewFake(start)
+	client := newFakeClient()
+
+	// a missing file is fine
+	s := NewScheduler(WithClock(c), WithPersistence(path))
+	if err := s.Restore(client, FireMissed); err != nil {
+		t.Fatal(err)
+	}
+	s.DelayMessage(client, "amp", "amp/set", 1, false, "off", 30*time.Minute)
+	s.DelayMessage(client, "tv", "tv/set", 0, true, "off", 2*time.Hour)
+	s.Schedule("func", time.Minute, func() {})
+	if ids := persistedIDs(t, path); ids != "amp,tv" {
+		t.Fatalf("Messages %s were persisted", ids)
+	}
+
+	// pending messages remain in the file after a shutdown with DropPending
+	s.Shutdown(DropPending)
+	if ids := persistedIDs(t, path); ids != "amp,tv" {
+		t.Fatalf("Messages %s remained after the shutdown", ids)
+	}
+
+	s = NewScheduler(WithClock(c), WithPersistence(path))
+	if err := s.Restore(client, FireMissed); err != nil {
+		t.Fatal(err)
+	}
+	p := s.Pending()
+	if len(p) != 2 || p[0].ID != "amp" || !p[0].Due.Equal(start.Add(30*time.Minute)) || p[1].ID != "tv" || !p[1].Due.Equal(start.Add(2*time.Hour)) {
+		t.Fatalf("Unexpected pending jobs %v after restoring", p)
+	}
+
+	// a published message is removed from the file
+	c.Advance(30 * time.Minute)
+	client.expectPublished(t, "amp/set", "off")
+	if ids := persistedIDs(t, path); ids != "tv" {
+		t.Fatalf("Messages %s are persisted after publishing", ids)
+	}
+
+	// flushed messages are removed from the file
+	s.Shutdown(FlushPending)
+	client.expectPublished(t, "tv/set", "off")
+	if ids := persistedIDs(t, path); ids != "" {
+		t.Fatalf("Messages %s are persisted after flushing", ids)
+	}
+}
+
+func TestRestoreMissedMessages(t *testing.T) {
+	path, cleanup := tempPersistence(t)
+	defer cleanup()
+	c := clock.NewFake(time.Unix(1000, 0))
+	client := newFakeClient()
+
+	s := NewScheduler(WithClock(c), WithPersistence(path))
+	s.Restore(client, FireMissed)
+	s.DelayMessage(client, "amp", "amp/set", 1, false, "off", 30*time.Minute)
+	s.DelayMessage(client, "tv", "tv/set", 0, false, "off", 2*time.Hour)
+	s.Shutdown(DropPending)
+
+	// restart an hour later, the message for the amplifier was missed
+	c.Advance(time.Hour)
+	s = NewScheduler(WithClock(c), WithPersistence(path))
+	if err := s.Restore(client, DropMissed); err != nil {
+		t.Fatal(err)
+	}
+	if ids := pendingIDs(s); ids != "tv" {
+		t.Fatalf("Jobs %s are pending after dropping missed messages", ids)
+	}
+	if ids := persistedIDs(t, path); ids != "tv" {
+		t.Fatalf("Messages %s are persisted after dropping missed messages", ids)
+	}
+	s.Shutdown(DropPending)
+	client.expectNothingPublished(t)
+
+	// restart after the message for the TV was missed as well
+	c.Advance(2 * time.Hour)
+	s = NewScheduler(WithClock(c), WithPersistence(path))
+	if err := s.Restore(client, FireMissed); err != nil {
+		t.Fatal(err)
+	}
+	if p := s.Pending(); len(p) != 1 || p[0].ID != "tv" || !p[0].Due.Equal(c.Now()) {
+		t.Fatalf("Missed message is not due immediately: %v", p)
+	}
+	c.Advance(0)
+	client.expectPublished(t, "tv/set", "off")
+	if ids := persistedIDs(t, path); ids != "" {
+		t.Fatalf("Messages %s are persisted after publishing", ids)
+	}
+}
+
+func TestPersistenceBeforeRestore(t *testing.T) {
+	path, cleanup := tempPersistence(t)
+	defer cleanup()
+	c := clock.NewFake(time.Unix(1000, 0))
+	client := newFakeClient()
+
+	s := NewScheduler(WithClock(c), WithPersistence(path))
+	s.Restore(client, FireMissed)
+	s.DelayMessage(client, "amp", "amp/set", 1, false, "off", time.Hour)
+	s.DelayMessage(client, "tv", "tv/set", 1, false, "off", time.Hour)
+	s.Shutdown(DropPending)
+
+	// the file is not written before the messages were restored
+	s = NewScheduler(WithClock(c), WithPersistence(path))
+	s.DelayMessage(client, "tv", "tv/set", 1, false, "on", time.Minute)
+	s.DelayMessage(client, "light", "light/set", 1, false, "on", 2*time.Minute)
+	if ids := persistedIDs(t, path); ids != "amp,tv" {
+		t.Fatalf("Messages %s were persisted before restoring", ids)
+	}
+
+	// a persisted message is dropped in favour of a pending job with the same ID
+	if err := s.Restore(client, FireMissed); err != nil {
+		t.Fatal(err)
+	}
+	if ids := persistedIDs(t, path); ids != "tv,light,amp" {
+		t.Fatalf("Messages %s are persisted after restoring", ids)
+	}
+	c.Advance(time.Hour)
+	client.expectPublished(t, "tv/set", "on")
+	client.expectPublished(t, "light/set", "on")
+	client.expectPublished(t, "amp/set", "off")
+	client.expectNothingPublished(t)
+	s.Shutdown(DropPending)
+
+	// restoring after the shutdown would lose the messages
+	if err := s.Restore(client, FireMissed); err == nil {
+		t.Fatal("Messages were restored after the shutdown")
+	}
+	if err := NewScheduler().Restore(client, FireMissed); err == nil {
+		t.Fatal("Messages were restored without persistence")
+	}
+}
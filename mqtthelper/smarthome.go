@@ -1,6 +1,7 @@
 package mqtthelper
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -11,13 +12,22 @@ import (
 
 	"github.com/coreos/go-systemd/daemon"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/frado1/libs/retry"
 )
 
 // SmartHomeBroker represents a broker
 type SmartHomeBroker struct {
-	mqttClient              mqtt.Client
-	URI                     string
-	TopLevelTopic           string
+	mqttClient    mqtt.Client
+	URI           string
+	TopLevelTopic string
+	// Options configures the connection further, its URI is replaced by the URI of the broker
+	Options ClientOptions
+	// QoS is used for status messages unless another QoS is given
+	QoS byte
+	// ConnectedQoS is used for the connected topic including the last will
+	ConnectedQoS byte
+	// SubscribeOptions are used for subscriptions unless other options are given
+	SubscribeOptions        SubscribeOptions
 	OnConnectHandler        SmartHomeOnConnectHandler
 	OnConnectionLostHandler SmartHomeOnConnectionLostHandler
 	msgCh                   chan messageToHandle
@@ -48,35 +58,64 @@ func NewSmartHomeBroker(uri string, topLevelTopic string) *SmartHomeBroker {
 
 // Connect tries to establish a connection to MQTT
 func (b *SmartHomeBroker) Connect() error {
+	return b.ConnectContext(context.Background())
+}
+
+// ConnectContext tries to establish a connection to MQTT, aborting when the context is done
+//
+// An aborted attempt can't be cancelled and may still connect the broker afterwards, Disconnect closes such a connection.
+func (b *SmartHomeBroker) ConnectContext(ctx context.Context) error {
 	if b.mqttClient == nil {
-		b.mqttClient = mqtt.NewClient(b.getOptions())
+		ops, err := b.getOptions()
+		if err != nil {
+			return fmt.Errorf("Invalid options to connect to MQTT at %s: %s", b.URI, err)
+		}
+		b.mqttClient = mqtt.NewClient(ops)
 	}
 
-	if token := b.mqttClient.Connect(); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("Could not connect to MQTT at %s: %s", b.URI, token.Error())
+	if err := WaitToken(ctx, b.mqttClient.Connect()); err != nil {
+		return fmt.Errorf("Could not connect to MQTT at %s: %s", b.URI, err)
 	}
 
 	return nil
 }
 
+// ConnectRetry tries to establish a connection to MQTT until it succeeds according to the retry policy
+func (b *SmartHomeBroker) ConnectRetry(ctx context.Context, policy retry.Policy) error {
+	_, err := policy.Do(ctx, func(ctx context.Context) error {
+		err := b.ConnectContext(ctx)
+		if err != nil {
+			log.Print(err)
+		}
+		return err
+	})
+
+	return err
+}
+
 // Disconnect closes the connection to MQTT
 func (b *SmartHomeBroker) Disconnect() {
 	if b.mqttClient == nil {
 		return
 	}
-	b.publish(b.connectedTopic(), 0, true, "0")
+	b.publish(context.Background(), b.connectedTopic(), b.ConnectedQoS, true, "0")
 	b.mqttClient.Disconnect(100)
 }
 
 // SetConnectionState sets the current state of the connection ("0": Disconnected from MQTT, "1": Connected to MQTT, but disconnected from hardware, "2": Fully operational)
 func (b *SmartHomeBroker) SetConnectionState(connected bool) error {
+	return b.SetConnectionStateContext(context.Background(), connected)
+}
+
+// SetConnectionStateContext sets the current state of the connection, aborting when the context is done
+func (b *SmartHomeBroker) SetConnectionStateContext(ctx context.Context, connected bool) error {
 	if b.mqttClient == nil {
 		return fmt.Errorf("Not connected to MQTT, cannot set connection state to %s", strconv.FormatBool(connected))
 	}
 	if connected {
-		b.publish(b.connectedTopic(), 0, true, "2")
+		b.publish(ctx, b.connectedTopic(), b.ConnectedQoS, true, "2")
 	} else {
-		b.publish(b.connectedTopic(), 0, true, "1")
+		b.publish(ctx, b.connectedTopic(), b.ConnectedQoS, true, "1")
 	}
 
 	return nil
@@ -87,36 +126,100 @@ func (b *SmartHomeBroker) SubscribeAction(item string, h SmartHomeMessageHandler
 	return b.Subscribe(b.actionTopic(item), h)
 }
 
+// SubscribeActionContext registers a subscription to actions of the specified item, aborting when the context is done
+func (b *SmartHomeBroker) SubscribeActionContext(ctx context.Context, item string, h SmartHomeMessageHandler) error {
+	return b.SubscribeContext(ctx, b.actionTopic(item), h)
+}
+
+// SubscribeActionWithOptions registers a subscription to actions of the specified item with the given options
+func (b *SmartHomeBroker) SubscribeActionWithOptions(item string, h SmartHomeMessageHandler, o SubscribeOptions) error {
+	return b.SubscribeWithOptionsContext(context.Background(), b.actionTopic(item), h, o)
+}
+
+// SubscribeActionWithOptionsContext registers a subscription to actions of the specified item with the given options, aborting when the context is done
+func (b *SmartHomeBroker) SubscribeActionWithOptionsContext(ctx context.Context, item string, h SmartHomeMessageHandler, o SubscribeOptions) error {
+	return b.SubscribeWithOptionsContext(ctx, b.actionTopic(item), h, o)
+}
+
 // Subscribe registers a subscription to the specified topic
 func (b *SmartHomeBroker) Subscribe(topic string, h SmartHomeMessageHandler) error {
+	return b.SubscribeContext(context.Background(), topic, h)
+}
+
+// SubscribeContext registers a subscription to the specified topic, aborting when the context is done
+func (b *SmartHomeBroker) SubscribeContext(ctx context.Context, topic string, h SmartHomeMessageHandler) error {
+	return b.SubscribeWithOptionsContext(ctx, topic, h, b.SubscribeOptions)
+}
+
+// SubscribeWithOptions registers a subscription to the specified topic with the given options
+func (b *SmartHomeBroker) SubscribeWithOptions(topic string, h SmartHomeMessageHandler, o SubscribeOptions) error {
+	return b.SubscribeWithOptionsContext(context.Background(), topic, h, o)
+}
+
+// SubscribeWithOptionsContext registers a subscription to the specified topic with the given options, aborting when the context is done
+func (b *SmartHomeBroker) SubscribeWithOptionsContext(ctx context.Context, topic string, h SmartHomeMessageHandler, o SubscribeOptions) error {
 	if b.mqttClient == nil {
 		return fmt.Errorf("Not connected to MQTT, cannot subscribe to %s", topic)
 	}
 
 	f := func(mqttClient mqtt.Client, msg mqtt.Message) {
+		if o.NoRetained && msg.Retained() {
+			log.Printf("Dropped retained message '%s' through topic %s", msg.Payload(), msg.Topic())
+			return
+		}
 		b.msgCh <- messageToHandle{
 			handler: h,
 			message: msg,
 		}
 	}
 
-	if token := b.mqttClient.Subscribe(topic, 0, f); token.Wait() && token.Error() != nil {
-		return fmt.Errorf("Failed to subscribe to topic %s: %s", topic, token.Error())
+	if err := WaitToken(ctx, b.mqttClient.Subscribe(topic, o.QoS, f)); err != nil {
+		return fmt.Errorf("Failed to subscribe to topic %s: %s", topic, err)
 	}
 	return nil
 }
 
 // PublishSimpleStatus sends a simple status message for the specified item
 func (b *SmartHomeBroker) PublishSimpleStatus(item string, payload string) error {
+	return b.PublishSimpleStatusContext(context.Background(), item, payload)
+}
+
+// PublishSimpleStatusContext sends a simple status message for the specified item, aborting when the context is done
+func (b *SmartHomeBroker) PublishSimpleStatusContext(ctx context.Context, item string, payload string) error {
+	return b.PublishSimpleStatusQoSContext(ctx, item, payload, b.QoS)
+}
+
+// PublishSimpleStatusQoS sends a simple status message for the specified item with the given QoS
+func (b *SmartHomeBroker) PublishSimpleStatusQoS(item string, payload string, qos byte) error {
+	return b.PublishSimpleStatusQoSContext(context.Background(), item, payload, qos)
+}
+
+// PublishSimpleStatusQoSContext sends a simple status message for the specified item with the given QoS, aborting when the context is done
+func (b *SmartHomeBroker) PublishSimpleStatusQoSContext(ctx context.Context, item string, payload string, qos byte) error {
 	if b.mqttClient == nil {
 		return fmt.Errorf("Not connected to MQTT, cannot publish simple status for %s", item)
 	}
 
-	return b.publish(b.statusTopic(item), 0, true, payload)
+	return b.publish(ctx, b.statusTopic(item), qos, true, payload)
 }
 
 // PublishStatus sends a status message for the specified item
 func (b *SmartHomeBroker) PublishStatus(item string, payload interface{}) error {
+	return b.PublishStatusContext(context.Background(), item, payload)
+}
+
+// PublishStatusContext sends a status message for the specified item, aborting when the context is done
+func (b *SmartHomeBroker) PublishStatusContext(ctx context.Context, item string, payload interface{}) error {
+	return b.PublishStatusQoSContext(ctx, item, payload, b.QoS)
+}
+
+// PublishStatusQoS sends a status message for the specified item with the given QoS
+func (b *SmartHomeBroker) PublishStatusQoS(item string, payload interface{}, qos byte) error {
+	return b.PublishStatusQoSContext(context.Background(), item, payload, qos)
+}
+
+// PublishStatusQoSContext sends a status message for the specified item with the given QoS, aborting when the context is done
+func (b *SmartHomeBroker) PublishStatusQoSContext(ctx context.Context, item string, payload interface{}, qos byte) error {
 	if b.mqttClient == nil {
 		return fmt.Errorf("Not connected to MQTT, cannot publish simple status for %s", item)
 	}
@@ -126,12 +229,17 @@ func (b *SmartHomeBroker) PublishStatus(item string, payload interface{}) error
 		return fmt.Errorf("Failed to marshal JSON for status of %s: %s", item, err)
 	}
 
-	return b.publish(b.statusTopic(item), 0, true, string(p))
+	return b.publish(ctx, b.statusTopic(item), qos, true, string(p))
 }
 
 // Run starts the main loop of the broker
 func (b *SmartHomeBroker) Run() error {
-	if err := b.Connect(); err != nil {
+	return b.RunContext(context.Background())
+}
+
+// RunContext starts the main loop of the broker, which stops when the context is done
+func (b *SmartHomeBroker) RunContext(ctx context.Context) error {
+	if err := b.ConnectContext(ctx); err != nil {
 		return err
 	}
 	defer b.Disconnect()
@@ -149,18 +257,25 @@ func (b *SmartHomeBroker) Run() error {
 			msgToHandle.handler(b, msg)
 		case <-signalChannel:
 			running = false
+		case <-ctx.Done():
+			running = false
 		}
 	}
 
 	return nil
 }
 
-func (b *SmartHomeBroker) getOptions() *mqtt.ClientOptions {
-	ops := mqtt.NewClientOptions().AddBroker(b.URI)
+func (b *SmartHomeBroker) getOptions() (*mqtt.ClientOptions, error) {
+	o := b.Options
+	o.URI = b.URI
+	ops, err := o.MQTTOptions()
+	if err != nil {
+		return nil, err
+	}
 
 	ops.SetConnectionLostHandler(func(mqttClient mqtt.Client, err error) {
 		log.Printf("Connection to MQTT at %s lost: %s", b.URI, err)
-		b.publish(b.connectedTopic(), 0, true, "0")
+		b.publish(context.Background(), b.connectedTopic(), b.ConnectedQoS, true, "0")
 		if nil != b.OnConnectionLostHandler {
 			b.OnConnectionLostHandler(b)
 		}
@@ -168,21 +283,20 @@ func (b *SmartHomeBroker) getOptions() *mqtt.ClientOptions {
 
 	ops.SetOnConnectHandler(func(mqttClient mqtt.Client) {
 		log.Printf("Connected to MQTT at %s", b.URI)
-		b.publish(b.connectedTopic(), 0, true, "1")
+		b.publish(context.Background(), b.connectedTopic(), b.ConnectedQoS, true, "1")
 		if nil != b.OnConnectHandler {
 			b.OnConnectHandler(b)
 		}
 	})
 
-	ops.SetWill(b.connectedTopic(), "0", 0, true)
+	ops.SetWill(b.connectedTopic(), "0", b.ConnectedQoS, true)
 
-	return ops
+	return ops, nil
 }
 
-func (b *SmartHomeBroker) publish(topic string, qos byte, retained bool, payload string) error {
-	token := b.mqttClient.Publish(topic, qos, retained, payload)
-	if token.Wait() && token.Error() != nil {
-		return fmt.Errorf("Could not publish message '%s' to topic %s: %s", payload, topic, token.Error())
+func (b *SmartHomeBroker) publish(ctx context.Context, topic string, qos byte, retained bool, payload string) error {
+	if err := WaitToken(ctx, b.mqttClient.Publish(topic, qos, retained, payload)); err != nil {
+		return fmt.Errorf("Could not publish message '%s' to topic %s: %s", payload, topic, err)
 	}
 	log.Printf("Published message '%s' to topic %s (retained: %s)", payload, topic, strconv.FormatBool(retained))
 	return nil
@@ -0,0 +1,312 @@
+package mqtthelper
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/frado1/libs/statestore"
+)
+
+var templatePlaceholder = regexp.MustCompile(`\{(\d+)\}`)
+
+// StateBinding binds states of a state store to MQTT topics
+type StateBinding struct {
+	// Topic is the subscribed topic, it may contain the wildcards + and #
+	Topic string
+	// Key is the template for the name of the state, {1}, {2}, ... are replaced by the levels matched by the wildcards
+	Key string
+	// Publish enables publishing local changes of the states
+	Publish bool
+	// PublishTopic is the topic local changes are published to, its wildcards are replaced by the ones of the state name, defaults to Topic
+	PublishTopic string
+	// QoS is used for published messages and the subscription
+	QoS byte
+	// Retained is used for published messages
+	Retained bool
+	// PublishDeleted enables publishing an empty message when a state is deleted, deleted states aren't published otherwise
+	PublishDeleted bool
+
+	wildcards []string
+	keyRegexp *regexp.Regexp
+	keyGroups []int
+}
+
+// StatusBinding returns a binding for all status topics of a SmartHomeBroker
+//
+// The states are named after the item, prefixed with the given prefix.
+// If publishing is enabled, local changes are sent to the action topics of the broker.
+func StatusBinding(topLevelTopic string, keyPrefix string) StateBinding {
+	return StateBinding{
+		Topic:        topLevelTopic + "/status/+",
+		Key:          keyPrefix + "{1}",
+		PublishTopic: topLevelTopic + "/set/+",
+	}
+}
+
+// StateMirror keeps states of a state store in sync with MQTT topics
+type StateMirror struct {
+	client   mqtt.Client
+	store    *statestore.StateStore
+	bindings []*StateBinding
+	// received holds the states which were received from MQTT per name until their changes were seen, so they aren't echoed
+	received map[string][]string
+	watcher  *statestore.Watcher
+	mutex    *sync.Mutex
+}
+
+// NewStateMirror creates a new mirror between the client and the state store
+func NewStateMirror(c mqtt.Client, s *statestore.StateStore) *StateMirror {
+	m := &StateMirror{
+		client:   c,
+		store:    s,
+		received: map[string][]string{},
+		watcher:  s.WatchPrefix("", statestore.WithBuffer(256)),
+		mutex:    &sync.Mutex{},
+	}
+
+	go m.publishChanges()
+
+	return m
+}
+
+// Bind adds the binding and subscribes to its topic
+//
+// Retained and live messages update the states. If publishing is enabled, local changes are published.
+func (m *StateMirror) Bind(b StateBinding) error {
+	if err := b.compile(); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	m.bindings = append(m.bindings, &b)
+	m.mutex.Unlock()
+
+	return m.subscribe(&b)
+}
+
+// Resubscribe subscribes to the topics of all bindings again, e.g. after reconnecting to MQTT
+func (m *StateMirror) Resubscribe() error {
+	m.mutex.Lock()
+	bindings := append([]*StateBinding{}, m.bindings...)
+	m.mutex.Unlock()
+
+	for _, b := range bindings {
+		if err := m.subscribe(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close stops publishing local changes
+func (m *StateMirror) Close() {
+	m.watcher.Close()
+}
+
+func (m *StateMirror) subscribe(b *StateBinding) error {
+	return SubscribeHandlerWithOptions(m.client, b.Topic, func(c mqtt.Client, msg mqtt.Message) {
+		levels, ok := matchTopic(b.Topic, msg.Topic())
+		if !ok {
+			return
+		}
+
+		name := fillTemplate(b.Key, levels)
+		state := string(msg.Payload())
+
+		m.mutex.Lock()
+		m.received[name] = append(m.received[name], state)
+		m.mutex.Unlock()
+
+		if _, changed := m.store.Store(name, state); !changed {
+			m.mutex.Lock()
+			m.forget(name, state)
+			m.mutex.Unlock()
+		}
+	}, SubscribeOptions{QoS: b.QoS})
+}
+
+func (m *StateMirror) publishChanges() {
+	for c := range m.watcher.C {
+		m.mutex.Lock()
+		echo := c.Kind != statestore.Deleted && m.consume(c.Name, c.NewState)
+		bindings := m.bindings
+		m.mutex.Unlock()
+
+		// don't echo states which were just received from MQTT
+		if echo {
+			continue
+		}
+
+		for _, b := range bindings {
+			if !b.Publish {
+				continue
+			}
+			if topic, ok := b.publishTopic(c.Name); ok {
+				if c.Kind == statestore.Deleted && !b.PublishDeleted {
+					break
+				}
+				PublishMessage(m.client, topic, b.QoS, b.Retained, c.NewState)
+				break
+			}
+		}
+	}
+}
+
+// consume checks whether the state was received from MQTT, the mutex has to be locked
+//
+// Received states before the matching one are discarded, their changes were superseded or dropped by the watcher.
+func (m *StateMirror) consume(name string, state string) bool {
+	received := m.received[name]
+	for i, r := range received {
+		if r == state {
+			if i+1 == len(received) {
+				delete(m.received, name)
+			} else {
+				m.received[name] = received[i+1:]
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+// forget removes a received state which didn't change the state store, the mutex has to be locked
+func (m *StateMirror) forget(name string, state string) {
+	received := m.received[name]
+	for i := len(received) - 1; i >= 0; i-- {
+		if received[i] == state {
+			received = append(received[:i], received[i+1:]...)
+			break
+		}
+	}
+
+	if len(received) == 0 {
+		delete(m.received, name)
+	} else {
+		m.received[name] = received
+	}
+}
+
+// compile validates the binding and prepares the reverse mapping from state names to topics
+func (b *StateBinding) compile() error {
+	if b.PublishTopic == "" {
+		b.PublishTopic = b.Topic
+	}
+
+	b.wildcards = topicWildcards(b.Topic)
+	if len(topicWildcards(b.PublishTopic)) != len(b.wildcards) {
+		return fmt.Errorf("Topic %s and publish topic %s of binding need the same wildcards", b.Topic, b.PublishTopic)
+	}
+
+	pattern := "^"
+	b.keyGroups = []int{}
+	last := 0
+	for _, loc := range templatePlaceholder.FindAllStringSubmatchIndex(b.Key, -1) {
+		i, _ := strconv.Atoi(b.Key[loc[2]:loc[3]])
+		if i < 1 || i > len(b.wildcards) {
+			return fmt.Errorf("Key %s of binding refers to wildcard %d, but topic %s has only %d", b.Key, i, b.Topic, len(b.wildcards))
+		}
+
+		pattern += regexp.QuoteMeta(b.Key[last:loc[0]])
+		if b.wildcards[i-1] == "#" {
+			pattern += "(.*)"
+		} else {
+			pattern += "([^/]+)"
+		}
+		b.keyGroups = append(b.keyGroups, i)
+		last = loc[1]
+	}
+	pattern += regexp.QuoteMeta(b.Key[last:]) + "$"
+
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Invalid key %s of binding: %s", b.Key, err)
+	}
+	b.keyRegexp = r
+
+	return nil
+}
+
+// publishTopic returns the topic for the given state name if it belongs to the binding
+func (b *StateBinding) publishTopic(name string) (string, bool) {
+	match := b.keyRegexp.FindStringSubmatch(name)
+	if match == nil {
+		return "", false
+	}
+
+	levels := make([]string, len(b.wildcards))
+	for g, i := range b.keyGroups {
+		if levels[i-1] != "" && levels[i-1] != match[g+1] {
+			return "", false
+		}
+		levels[i-1] = match[g+1]
+	}
+	for _, l := range levels {
+		if l == "" {
+			return "", false
+		}
+	}
+
+	parts := strings.Split(b.PublishTopic, "/")
+	w := 0
+	for i, p := range parts {
+		if p == "+" || p == "#" {
+			parts[i] = levels[w]
+			w++
+		}
+	}
+
+	return strings.Join(parts, "/"), true
+}
+
+// matchTopic checks the topic against a subscription with wildcards and returns the matched levels
+func matchTopic(pattern string, topic string) ([]string, bool) {
+	patternParts := strings.Split(pattern, "/")
+	topicParts := strings.Split(topic, "/")
+	levels := []string{}
+
+	for i, p := range patternParts {
+		if p == "#" {
+			return append(levels, strings.Join(topicParts[i:], "/")), true
+		}
+		if i >= len(topicParts) {
+			return nil, false
+		}
+		if p == "+" {
+			levels = append(levels, topicParts[i])
+		} else if p != topicParts[i] {
+			return nil, false
+		}
+	}
+
+	return levels, len(patternParts) == len(topicParts)
+}
+
+func topicWildcards(pattern string) []string {
+	wildcards := []string{}
+	for _, p := range strings.Split(pattern, "/") {
+		if p == "+" || p == "#" {
+			wildcards = append(wildcards, p)
+		}
+	}
+
+	return wildcards
+}
+
+func fillTemplate(template string, levels []string) string {
+	return templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		i, _ := strconv.Atoi(placeholder[1 : len(placeholder)-1])
+		if i < 1 || i > len(levels) {
+			log.Printf("Template %s refers to wildcard %d, but only %d are available", template, i, len(levels))
+			return ""
+		}
+		return levels[i-1]
+	})
+}
@@ -0,0 +1,256 @@
+package mqtthelper
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/frado1/libs/statestore"
+)
+
+type fakeToken struct {
+	mqtt.Token
+}
+
+func (fakeToken) Wait() bool   { return true }
+func (fakeToken) Error() error { return nil }
+
+type fakeMessage struct {
+	mqtt.Message
+	topic    string
+	payload  string
+	retained bool
+}
+
+func (m fakeMessage) Topic() string   { return m.topic }
+func (m fakeMessage) Payload() []byte { return []byte(m.payload) }
+func (m fakeMessage) Retained() bool  { return m.retained }
+
+// fakeClient records published messages and delivers messages to the subscribed handlers
+type fakeClient struct {
+	mqtt.Client
+	published chan fakeMessage
+	handlers  map[string]mqtt.MessageHandler
+	mutex     *sync.Mutex
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		published: make(chan fakeMessage, 16),
+		handlers:  map[string]mqtt.MessageHandler{},
+		mutex:     &sync.Mutex{},
+	}
+}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.published <- fakeMessage{topic: topic, payload: payload.(string), retained: retained}
+	return fakeToken{}
+}
+
+func (c *fakeClient) Subscribe(topic string, qos byte, handler mqtt.MessageHandler) mqtt.Token {
+	c.mutex.Lock()
+	c.handlers[topic] = handler
+	c.mutex.Unlock()
+	return fakeToken{}
+}
+
+func (c *fakeClient) deliver(subscription string, topic string, payload string) {
+	c.mutex.Lock()
+	h := c.handlers[subscription]
+	c.mutex.Unlock()
+	h(c, fakeMessage{topic: topic, payload: payload})
+}
+
+func (c *fakeClient) expectPublished(t *testing.T, topic string, payload string) {
+	select {
+	case m := <-c.published:
+		if m.topic != topic || m.payload != payload {
+			t.Fatalf("Published '%s' to %s instead of '%s' to %s", m.payload, m.topic, payload, topic)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Nothing was published instead of '%s' to %s", payload, topic)
+	}
+}
+
+func (c *fakeClient) expectNothingPublished(t *testing.T) {
+	select {
+	case m := <-c.published:
+		t.Fatalf("Unexpectedly published '%s' to %s", m.payload, m.topic)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		topic   string
+		levels  []string
+		ok      bool
+	}{
+		{"kodi/status/volume", "kodi/status/volume", []string{}, true},
+		{"kodi/status/volume", "kodi/status/mute", nil, false},
+		{"kodi/status/+", "kodi/status/volume", []string{"volume"}, true},
+		{"kodi/status/+", "kodi/status/volume/x", nil, false},
+		{"kodi/status/+", "kodi/status", nil, false},
+		{"+/status/+", "lgtv/status/power", []string{"lgtv", "power"}, true},
+		{"kodi/#", "kodi/status/volume", []string{"status/volume"}, true},
+		{"kodi/+/#", "kodi/status/player/title", []string{"status", "player/title"}, true},
+	}
+
+	for _, test := range tests {
+		levels, ok := matchTopic(test.pattern, test.topic)
+		if ok != test.ok || (ok && !reflect.DeepEqual(levels, test.levels)) {
+			t.Errorf("matchTopic(%s, %s) returned %v, %t instead of %v, %t", test.pattern, test.topic, levels, ok, test.levels, test.ok)
+		}
+	}
+}
+
+func TestFillTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		levels   []string
+		result   string
+	}{
+		{"tv/power", nil, "tv/power"},
+		{"tv/{1}", []string{"power"}, "tv/power"},
+		{"{2}/{1}", []string{"power", "lgtv"}, "lgtv/power"},
+		{"tv/{2}", []string{"power"}, "tv/"},
+	}
+
+	for _, test := range tests {
+		if result := fillTemplate(test.template, test.levels); result != test.result {
+			t.Errorf("fillTemplate(%s, %v) returned %s instead of %s", test.template, test.levels, result, test.result)
+		}
+	}
+}
+
+func TestBindingPublishTopic(t *testing.T) {
+	b := StateBinding{Topic: "lgtv/status/+/x/#", Key: "tv/{1}/{2}", PublishTopic: "lgtv/set/+/y/#"}
+	if err := b.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if topic, ok := b.publishTopic("tv/power/a/b"); !ok || topic != "lgtv/set/power/y/a/b" {
+		t.Errorf("Unexpected publish topic %s, %t", topic, ok)
+	}
+	if topic, ok := b.publishTopic("kodi/power/a"); ok {
+		t.Errorf("Unexpected publish topic %s for a state of another binding", topic)
+	}
+
+	s := StatusBinding("kodi", "kodi/")
+	if err := s.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if topic, ok := s.publishTopic("kodi/volume"); !ok || topic != "kodi/set/volume" {
+		t.Errorf("Unexpected publish topic %s, %t of status binding", topic, ok)
+	}
+	if topic, ok := s.publishTopic("kodi/player/title"); ok {
+		t.Errorf("Unexpected publish topic %s for a name with more levels", topic)
+	}
+
+	// a key which uses the same wildcard twice only matches names with equal levels
+	d := StateBinding{Topic: "a/+", Key: "{1}-{1}"}
+	if err := d.compile(); err != nil {
+		t.Fatal(err)
+	}
+	if topic, ok := d.publishTopic("x-x"); !ok || topic != "a/x" {
+		t.Errorf("Unexpected publish topic %s, %t", topic, ok)
+	}
+	if topic, ok := d.publishTopic("x-y"); ok {
+		t.Errorf("Unexpected publish topic %s for different levels", topic)
+	}
+}
+
+func TestBindingCompileErrors(t *testing.T) {
+	bindings := []StateBinding{
+		{Topic: "a/+", Key: "{2}"},
+		{Topic: "a/+", Key: "{0}"},
+		{Topic: "a/+", Key: "{1}", PublishTopic: "a/b"},
+		{Topic: "a/b", Key: "{1}"},
+	}
+
+	for _, b := range bindings {
+		if err := b.compile(); err == nil {
+			t.Errorf("Binding with topic %s, key %s and publish topic %s compiled", b.Topic, b.Key, b.PublishTopic)
+		}
+	}
+}
+
+func TestStateMirror(t *testing.T) {
+	c := newFakeClient()
+	s := statestore.NewStateStore()
+	defer s.Close()
+	m := NewStateMirror(c, s)
+	defer m.Close()
+
+	b := StatusBinding("kodi", "kodi/")
+	b.Publish = true
+	if err := m.Bind(b); err != nil {
+		t.Fatal(err)
+	}
+
+	c.deliver("kodi/status/+", "kodi/status/volume", "50")
+	if state := s.Get("kodi/volume"); state != "50" {
+		t.Fatalf("Received state was stored as '%s'", state)
+	}
+	// received states aren't echoed
+	c.expectNothingPublished(t)
+
+	s.Store("kodi/volume", "60")
+	c.expectPublished(t, "kodi/set/volume", "60")
+
+	s.Delete("kodi/volume")
+	c.expectNothingPublished(t)
+}
+
+func TestStateMirrorPublishDeleted(t *testing.T) {
+	c := newFakeClient()
+	s := statestore.NewStateStore()
+	defer s.Close()
+	m := NewStateMirror(c, s)
+	defer m.Close()
+
+	if err := m.Bind(StateBinding{Topic: "lgtv/+", Key: "tv/{1}", Publish: true, PublishDeleted: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	s.Store("tv/app", "netflix")
+	c.expectPublished(t, "lgtv/app", "netflix")
+	s.Delete("tv/app")
+	c.expectPublished(t, "lgtv/app", "")
+}
+
+func TestStateMirrorBurst(t *testing.T) {
+	c := newFakeClient()
+	s := statestore.NewStateStore()
+	defer s.Close()
+	m := NewStateMirror(c, s)
+	defer m.Close()
+
+	if err := m.Bind(StateBinding{Topic: "dev/status/+", Key: "dev/{1}", Publish: true, PublishTopic: "dev/set/+"}); err != nil {
+		t.Fatal(err)
+	}
+	// the publish topic defaults to the subscribed topic
+	if err := m.Bind(StateBinding{Topic: "sensor/+", Key: "sensor/{1}", Publish: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	// none of several messages received in a row for the same state is echoed
+	c.deliver("dev/status/+", "dev/status/tv", "on")
+	c.deliver("dev/status/+", "dev/status/tv", "off")
+	c.deliver("dev/status/+", "dev/status/tv", "off")
+	c.deliver("dev/status/+", "dev/status/tv", "on")
+	c.deliver("sensor/+", "sensor/temperature", "21.5")
+	c.deliver("sensor/+", "sensor/temperature", "21.6")
+	c.expectNothingPublished(t)
+	if state := s.Get("dev/tv"); state != "on" {
+		t.Fatalf("Received state was stored as '%s'", state)
+	}
+
+	// local changes are still published afterwards
+	s.Store("dev/tv", "off")
+	c.expectPublished(t, "dev/set/tv", "off")
+	s.Store("sensor/temperature", "20")
+	c.expectPublished(t, "sensor/temperature", "20")
+}
@@ -0,0 +1,126 @@
+package retry
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/frado1/libs/clock"
+)
+
+// DefaultInitial is the wait after the first attempt of a policy without a positive Initial
+const DefaultInitial = time.Second
+
+// Policy defines how long to wait between repeated attempts of an operation
+//
+// The first attempt happens immediately, the following ones after Initial, Initial*Multiplier and so on up to Max.
+type Policy struct {
+	// Initial defaults to DefaultInitial if it is not positive, so a zero policy doesn't retry without waiting
+	Initial time.Duration
+	// Max limits the wait between two attempts if not zero
+	Max time.Duration
+	// Multiplier increases the wait after every attempt, values below 1 keep it constant
+	Multiplier float64
+	// Jitter randomizes every wait by up to the given fraction, e.g. 0.2 for ±20%
+	Jitter float64
+	// MaxAttempts stops retrying after the given number of attempts if not zero
+	MaxAttempts int
+	// Clock defaults to the system clock
+	Clock clock.Clock
+}
+
+// Constant returns a policy which waits the same interval between all attempts
+func Constant(interval time.Duration) Policy {
+	return Policy{Initial: interval, Multiplier: 1}
+}
+
+// Exponential returns a policy which doubles the wait after every attempt up to max
+func Exponential(initial time.Duration, max time.Duration) Policy {
+	return Policy{Initial: initial, Max: max, Multiplier: 2}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Permanent wraps an error to stop retrying, Do returns the wrapped error
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// Delay returns the wait after the given attempt, counting from 1
+func (p Policy) Delay(attempt int) time.Duration {
+	if attempt < 1 {
+		return 0
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	initial := p.Initial
+	if initial <= 0 {
+		initial = DefaultInitial
+	}
+
+	d := float64(initial) * math.Pow(multiplier, float64(attempt-1))
+	if p.Max > 0 && d > float64(p.Max) {
+		d = float64(p.Max)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	if d < 0 {
+		return 0
+	}
+
+	return time.Duration(d)
+}
+
+// Do calls the function until it succeeds, returns a permanent error, MaxAttempts is reached or the context is done
+//
+// It returns the number of attempts and the error of the last attempt,
+// or the error of the context if it was done before the first attempt.
+func (p Policy) Do(ctx context.Context, f func(ctx context.Context) error) (int, error) {
+	c := p.Clock
+	if c == nil {
+		c = clock.System
+	}
+
+	attempts := 0
+	var lastErr error
+	for {
+		if err := ctx.Err(); err != nil {
+			if lastErr == nil {
+				lastErr = err
+			}
+			return attempts, lastErr
+		}
+
+		attempts++
+		err := f(ctx)
+		if err == nil {
+			return attempts, nil
+		}
+		if permanent, ok := err.(permanentError); ok {
+			return attempts, permanent.err
+		}
+		lastErr = err
+
+		if p.MaxAttempts > 0 && attempts >= p.MaxAttempts {
+			return attempts, lastErr
+		}
+
+		select {
+		case <-c.After(p.Delay(attempts)):
+		case <-ctx.Done():
+			return attempts, lastErr
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frado1/libs/clock"
+)
+
+var errFailed = errors.New("failed")
+
+// waitPending waits until a goroutine waits for the fake clock
+func waitPending(t *testing.T, c *clock.Fake) {
+	deadline := time.Now().Add(time.Second)
+	for c.Pending() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Nothing waits for the clock")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := Policy{Initial: time.Second, Max: 5 * time.Second, Multiplier: 2}
+	expected := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for attempt, e := range expected {
+		if d := p.Delay(attempt); d != e {
+			t.Errorf("Delay after attempt %d is %s instead of %s", attempt, d, e)
+		}
+	}
+
+	// multipliers below 1 keep the delay constant
+	p = Policy{Initial: time.Second, Multiplier: 0.5}
+	if d := p.Delay(4); d != time.Second {
+		t.Errorf("Delay with multiplier below 1 is %s", d)
+	}
+
+	// no maximum
+	p = Exponential(time.Second, 0)
+	if d := p.Delay(11); d != 1024*time.Second {
+		t.Errorf("Delay without maximum is %s", d)
+	}
+}
+
+func TestDelayJitter(t *testing.T) {
+	p := Policy{Initial: time.Second, Max: 4 * time.Second, Multiplier: 2, Jitter: 0.5}
+	for i := 0; i < 1000; i++ {
+		if d := p.Delay(1); d < 500*time.Millisecond || d > 1500*time.Millisecond {
+			t.Fatalf("Delay %s with jitter is out of bounds", d)
+		}
+		// the jitter is applied after the maximum
+		if d := p.Delay(5); d < 2*time.Second || d > 6*time.Second {
+			t.Fatalf("Capped delay %s with jitter is out of bounds", d)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	start := time.Unix(0, 0)
+	c := clock.NewFake(start)
+	p := Policy{Initial: time.Second, Multiplier: 2, Clock: c}
+
+	calls := make(chan time.Time, 10)
+	done := make(chan error)
+	go func() {
+		attempts, err := p.Do(context.Background(), func(ctx context.Context) error {
+			calls <- c.Now()
+			if len(calls) < 3 {
+				return errFailed
+			}
+			return nil
+		})
+		if attempts != 3 {
+			t.Errorf("Do returned %d attempts", attempts)
+		}
+		done <- err
+	}()
+
+	waitPending(t, c)
+	c.Advance(time.Second)
+	waitPending(t, c)
+	c.Advance(time.Second)
+	select {
+	case err := <-done:
+		t.Fatalf("Do returned %v before the delay passed", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	c.Advance(time.Second)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	expected := []time.Time{start, start.Add(time.Second), start.Add(3 * time.Second)}
+	for _, e := range expected {
+		if call := <-calls; !call.Equal(e) {
+			t.Fatalf("Attempt at %s instead of %s", call, e)
+		}
+	}
+}
+
+func TestDoMaxAttempts(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	p := Policy{Initial: time.Second, MaxAttempts: 3, Clock: c}
+
+	done := make(chan error)
+	calls := 0
+	go func() {
+		attempts, err := p.Do(context.Background(), func(ctx context.Context) error {
+			calls++
+			return errFailed
+		})
+		if attempts != 3 {
+			t.Errorf("Do returned %d attempts", attempts)
+		}
+		done <- err
+	}()
+
+	waitPending(t, c)
+	c.Advance(time.Second)
+	waitPending(t, c)
+	c.Advance(time.Second)
+
+	if err := <-done; err != errFailed {
+		t.Fatalf("Do returned %v instead of the last error", err)
+	}
+	if calls != 3 {
+		t.Fatalf("Function was called %d times", calls)
+	}
+}
+
+func TestDoPermanent(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	p := Policy{Initial: time.Second, Clock: c}
+
+	attempts, err := p.Do(context.Background(), func(ctx context.Context) error {
+		return Permanent(errFailed)
+	})
+	if attempts != 1 || err != errFailed {
+		t.Fatalf("Do returned %d, %v for a permanent error", attempts, err)
+	}
+}
+
+func TestDoContext(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	p := Policy{Initial: time.Second, Clock: c}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	attempts, err := p.Do(ctx, func(ctx context.Context) error {
+		return nil
+	})
+	if attempts != 0 || err != context.Canceled {
+		t.Fatalf("Do returned %d, %v for a cancelled context", attempts, err)
+	}
+
+	// cancelling while waiting for the next attempt returns the last error
+	ctx, cancel = context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		attempts, err := p.Do(ctx, func(ctx context.Context) error {
+			return errFailed
+		})
+		if attempts != 1 {
+			t.Errorf("Do returned %d attempts", attempts)
+		}
+		done <- err
+	}()
+
+	waitPending(t, c)
+	cancel()
+	select {
+	case err := <-done:
+		if err != errFailed {
+			t.Fatalf("Do returned %v instead of the last error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Do didn't return after the context was cancelled")
+	}
+}
+
+func TestZeroPolicy(t *testing.T) {
+	p := Policy{}
+	for attempt := 1; attempt < 5; attempt++ {
+		if d := p.Delay(attempt); d != DefaultInitial {
+			t.Fatalf("Delay of zero policy after attempt %d is %s", attempt, d)
+		}
+	}
+	if d := Exponential(0, time.Minute).Delay(3); d != 4*DefaultInitial {
+		t.Fatalf("Delay of exponential policy without initial wait is %s", d)
+	}
+
+	// a zero policy waits between the attempts instead of retrying in a tight loop
+	c := clock.NewFake(time.Unix(0, 0))
+	p.Clock = c
+	calls := make(chan struct{}, 10)
+	done := make(chan error)
+	go func() {
+		_, err := p.Do(context.Background(), func(ctx context.Context) error {
+			calls <- struct{}{}
+			if len(calls) < 2 {
+				return errFailed
+			}
+			return nil
+		})
+		done <- err
+	}()
+
+	waitPending(t, c)
+	select {
+	case err := <-done:
+		t.Fatalf("Do returned %v without waiting", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if len(calls) != 1 {
+		t.Fatalf("Function was called %d times before the clock advanced", len(calls))
+	}
+
+	c.Advance(DefaultInitial)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
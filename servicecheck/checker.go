@@ -1,36 +1,93 @@
 package servicecheck
 
 import (
-	"net"
+	"context"
+	"errors"
+	"fmt"
 	"time"
+
+	"github.com/frado1/libs/clock"
+	"github.com/frado1/libs/retry"
 )
 
+// ErrConditionFailed is reported if the condition stopped waiting before the first attempt
+var ErrConditionFailed = errors.New("Condition for waiting is not fulfilled anymore")
+
 type condFunc func() bool
 
 func PingService(network string, address string) error {
-	conn, err := net.DialTimeout(network, address, 50*time.Millisecond)
-	if conn != nil {
-		conn.Close()
+	return PingServiceContext(context.Background(), network, address)
+}
+
+func PingServiceContext(ctx context.Context, network string, address string) error {
+	return DialProbe{Network: network, Address: address, Timeout: 50 * time.Millisecond}.Probe(ctx)
+}
+
+// WaitResult describes the outcome of waiting for a service
+type WaitResult struct {
+	Available bool
+	Attempts  int
+	// LastError is the error of the last failed attempt or why waiting stopped before the first attempt
+	LastError error
+	Elapsed   time.Duration
+}
+
+func (r WaitResult) String() string {
+	if r.Available {
+		return fmt.Sprintf("available after %d attempts in %s", r.Attempts, r.Elapsed)
 	}
-	return err
+
+	return fmt.Sprintf("not available after %d attempts in %s: %s", r.Attempts, r.Elapsed, r.LastError)
 }
 
-func WaitForService(network string, address string, cond condFunc, timeout time.Duration) bool {
-	t := time.NewTimer(timeout)
-	tick := time.NewTicker(1 * time.Second)
-	defer t.Stop()
-	defer tick.Stop()
+func WaitForService(network string, address string, cond condFunc, timeout time.Duration) WaitResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return WaitForServiceContext(ctx, network, address, cond)
+}
 
-	for cond() {
-		select {
-		case <-tick.C:
-			if err := PingService(network, address); err == nil {
-				return true
+func WaitForServiceContext(ctx context.Context, network string, address string, cond condFunc) WaitResult {
+	return WaitForProbe(ctx, DialProbe{Network: network, Address: address, Timeout: 50 * time.Millisecond}, cond)
+}
+
+// DefaultRetryPolicy is used to wait for services if no other policy is given
+var DefaultRetryPolicy = retry.Policy{
+	Initial:    250 * time.Millisecond,
+	Max:        5 * time.Second,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+func WaitForProbe(ctx context.Context, p Probe, cond condFunc) WaitResult {
+	return WaitForProbeRetry(ctx, p, cond, DefaultRetryPolicy)
+}
+
+// WaitForProbeRetry probes immediately and then according to the policy until the probe succeeds,
+// the condition is false or the context is done
+func WaitForProbeRetry(ctx context.Context, p Probe, cond condFunc, policy retry.Policy) WaitResult {
+	c := policy.Clock
+	if c == nil {
+		c = clock.System
+	}
+	start := c.Now()
+
+	result := WaitResult{}
+	_, err := policy.Do(ctx, func(ctx context.Context) error {
+		if !cond() {
+			if result.LastError != nil {
+				return retry.Permanent(result.LastError)
 			}
-		case <-t.C:
-			break
+			return retry.Permanent(ErrConditionFailed)
 		}
-	}
+		result.Attempts++
+		result.LastError = p.Probe(ctx)
+		return result.LastError
+	})
+
+	result.Available = err == nil
+	result.LastError = err
+	result.Elapsed = c.Now().Sub(start)
 
-	return false
+	return result
 }
@@ -0,0 +1,101 @@
+package servicecheck
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/frado1/libs/clock"
+	"github.com/frado1/libs/retry"
+)
+
+var errRefused = errors.New("refused")
+
+func TestWaitForProbeRetry(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	policy := retry.Policy{Initial: time.Second, Multiplier: 2, Clock: c}
+
+	probes := 0
+	probe := ProbeFunc(func(ctx context.Context) error {
+		probes++
+		if probes < 3 {
+			return errRefused
+		}
+		return nil
+	})
+
+	done := make(chan WaitResult)
+	go func() {
+		done <- WaitForProbeRetry(context.Background(), probe, func() bool { return true }, policy)
+	}()
+
+	for _, d := range []time.Duration{time.Second, 2 * time.Second} {
+		for c.Pending() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		c.Advance(d)
+	}
+
+	r := <-done
+	if !r.Available || r.Attempts != 3 || r.LastError != nil || r.Elapsed != 3*time.Second {
+		t.Fatalf("Unexpected result %v", r)
+	}
+}
+
+func TestWaitForProbeRetryCondition(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	policy := retry.Policy{Initial: time.Second, Clock: c}
+
+	probes := 0
+	probe := ProbeFunc(func(ctx context.Context) error {
+		probes++
+		return errRefused
+	})
+
+	// the condition is checked before every probe, the last error of the probe is kept
+	done := make(chan WaitResult)
+	go func() {
+		done <- WaitForProbeRetry(context.Background(), probe, func() bool { return probes < 2 }, policy)
+	}()
+	for i := 0; i < 2; i++ {
+		for c.Pending() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		c.Advance(time.Second)
+	}
+
+	r := <-done
+	if r.Available || r.Attempts != 2 || r.LastError != errRefused {
+		t.Fatalf("Unexpected result %v", r)
+	}
+
+	// the condition fails before the first probe
+	r = WaitForProbeRetry(context.Background(), probe, func() bool { return false }, policy)
+	if r.Available || r.Attempts != 0 || r.LastError != ErrConditionFailed {
+		t.Fatalf("Unexpected result %v", r)
+	}
+}
+
+func TestWaitForProbeRetryContext(t *testing.T) {
+	c := clock.NewFake(time.Unix(0, 0))
+	policy := retry.Policy{Initial: time.Second, Clock: c}
+	probe := ProbeFunc(func(ctx context.Context) error {
+		return errRefused
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan WaitResult)
+	go func() {
+		done <- WaitForProbeRetry(ctx, probe, func() bool { return true }, policy)
+	}()
+	for c.Pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+
+	r := <-done
+	if r.Available || r.Attempts != 1 || r.LastError != errRefused {
+		t.Fatalf("Unexpected result %v", r)
+	}
+}
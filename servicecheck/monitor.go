@@ -0,0 +1,241 @@
+package servicecheck
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status represents the availability of a service
+type Status int
+
+const (
+	// Unknown means that the service was not probed yet
+	Unknown Status = iota
+	// Up means that the service is available
+	Up
+	// Down means that the service is not available
+	Down
+)
+
+func (s Status) String() string {
+	switch s {
+	case Up:
+		return "up"
+	case Down:
+		return "down"
+	default:
+		return "unknown"
+	}
+}
+
+// ServiceStatus describes the current availability of a monitored service
+type ServiceStatus struct {
+	Name   string
+	Status Status
+	// Latency is the duration of the last probe
+	Latency time.Duration
+	// LastChange is the time the status changed the last time
+	LastChange time.Time
+	// LastError is the error of the last failed probe
+	LastError error
+
+	failures  int
+	successes int
+}
+
+// Event reports a change of the availability of a service
+type Event struct {
+	ServiceStatus
+	Previous Status
+}
+
+// DefaultInterval is used by a monitor without a positive interval
+const DefaultInterval = 30 * time.Second
+
+// Monitor probes several services on an interval and reports changes of their availability
+//
+// A service is only considered down after FailureThreshold consecutive failed probes
+// and up again after SuccessThreshold consecutive successful probes.
+// The first probe of a service determines its initial status regardless of the thresholds.
+type Monitor struct {
+	// Interval between two probes of all services, DefaultInterval is used if it is not positive
+	Interval         time.Duration
+	FailureThreshold int
+	SuccessThreshold int
+	// OnChange is called from the goroutine of Run for every change of the status if not nil
+	OnChange func(Event)
+
+	probes   map[string]Probe
+	statuses map[string]*ServiceStatus
+	events   chan Event
+	mutex    *sync.Mutex
+}
+
+// NewMonitor creates a new monitor which probes on the given interval
+func NewMonitor(interval time.Duration) *Monitor {
+	return &Monitor{
+		Interval:         interval,
+		FailureThreshold: 3,
+		SuccessThreshold: 1,
+		probes:           map[string]Probe{},
+		statuses:         map[string]*ServiceStatus{},
+		events:           make(chan Event, 64),
+		mutex:            &sync.Mutex{},
+	}
+}
+
+// Add registers a service to be monitored with the given probe
+func (m *Monitor) Add(name string, p Probe) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.probes[name] = p
+	m.statuses[name] = &ServiceStatus{Name: name}
+}
+
+// Remove stops monitoring the given service
+func (m *Monitor) Remove(name string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.probes, name)
+	delete(m.statuses, name)
+}
+
+// Events returns a channel which receives all changes of the status
+//
+// Events are dropped if the channel is full, so it has to be consumed if it is used at all.
+func (m *Monitor) Events() <-chan Event {
+	return m.events
+}
+
+// Status returns the current status of the given service
+func (m *Monitor) Status(name string) (ServiceStatus, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	s, ok := m.statuses[name]
+	if !ok {
+		return ServiceStatus{}, false
+	}
+
+	return *s, true
+}
+
+// Statuses returns the current status of all services ordered by name
+func (m *Monitor) Statuses() []ServiceStatus {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	names := []string{}
+	for name := range m.statuses {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statuses := []ServiceStatus{}
+	for _, name := range names {
+		statuses = append(statuses, *m.statuses[name])
+	}
+
+	return statuses
+}
+
+// Run probes all services immediately and then on every interval until the context is done
+func (m *Monitor) Run(ctx context.Context) {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		m.probeAll(ctx)
+
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *Monitor) probeAll(ctx context.Context) {
+	m.mutex.Lock()
+	probes := make(map[string]Probe, len(m.probes))
+	for name, p := range m.probes {
+		probes[name] = p
+	}
+	m.mutex.Unlock()
+
+	type result struct {
+		name    string
+		err     error
+		latency time.Duration
+	}
+
+	// probe concurrently, but report the results from the goroutine of Run only
+	results := make(chan result, len(probes))
+	for name, p := range probes {
+		go func(name string, p Probe) {
+			start := time.Now()
+			err := p.Probe(ctx)
+			results <- result{name: name, err: err, latency: time.Since(start)}
+		}(name, p)
+	}
+
+	for range probes {
+		r := <-results
+		if ctx.Err() == nil {
+			m.update(r.name, r.err, r.latency)
+		}
+	}
+}
+
+// update applies the result of a probe and reports a change of the status
+func (m *Monitor) update(name string, err error, latency time.Duration) {
+	m.mutex.Lock()
+	s, ok := m.statuses[name]
+	if !ok {
+		m.mutex.Unlock()
+		return
+	}
+
+	s.Latency = latency
+	previous := s.Status
+	if err != nil {
+		s.LastError = err
+		s.failures++
+		s.successes = 0
+		if previous == Unknown || (previous == Up && s.failures >= m.FailureThreshold) {
+			s.Status = Down
+		}
+	} else {
+		s.successes++
+		s.failures = 0
+		if previous == Unknown || (previous == Down && s.successes >= m.SuccessThreshold) {
+			s.Status = Up
+		}
+	}
+
+	if s.Status == previous {
+		m.mutex.Unlock()
+		return
+	}
+	s.LastChange = time.Now()
+	e := Event{ServiceStatus: *s, Previous: previous}
+	m.mutex.Unlock()
+
+	log.Printf("Service %s is %s", name, e.Status)
+	if m.OnChange != nil {
+		m.OnChange(e)
+	}
+	select {
+	case m.events <- e:
+	default:
+	}
+}
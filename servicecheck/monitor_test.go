@@ -0,0 +1,39 @@
+package servicecheck
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMonitorWithoutInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		m := NewMonitor(interval)
+		m.Add("nas", ProbeFunc(func(ctx context.Context) error {
+			return nil
+		}))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			m.Run(ctx)
+			close(done)
+		}()
+
+		select {
+		case e := <-m.Events():
+			if e.Name != "nas" || e.Status != Up {
+				t.Fatalf("Unexpected event %v", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Monitor with interval %s didn't probe", interval)
+		}
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Monitor with interval %s didn't stop", interval)
+		}
+	}
+}
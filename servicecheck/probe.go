@@ -0,0 +1,247 @@
+package servicecheck
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout is used by probes without a configured timeout
+const DefaultTimeout = 1 * time.Second
+
+const maxBodySize = 1 << 20
+
+// Probe checks whether a service is available
+type Probe interface {
+	Probe(ctx context.Context) error
+}
+
+// ProbeFunc adapts an ordinary function to a probe
+type ProbeFunc func(ctx context.Context) error
+
+// Probe calls the function
+func (f ProbeFunc) Probe(ctx context.Context) error {
+	return f(ctx)
+}
+
+// DialProbe checks that a connection to the address can be opened
+type DialProbe struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+// Probe opens and closes a connection
+func (p DialProbe) Probe(ctx context.Context) error {
+	_, release, err := dial(ctx, p.Network, p.Address, p.Timeout)
+	if err != nil {
+		return err
+	}
+	release()
+
+	return nil
+}
+
+// HTTPProbe checks that an HTTP(S) request to the URL succeeds
+type HTTPProbe struct {
+	URL string
+	// Method defaults to GET
+	Method string
+	// Body is sent with the request, e.g. for JSON-RPC calls
+	Body   string
+	Header http.Header
+	// ExpectedStatus is the required status code, if zero any 2xx status is accepted
+	ExpectedStatus int
+	// BodyContains is required to be part of the response body if not empty
+	BodyContains string
+	// BodyPattern is required to match the response body if not nil
+	BodyPattern        *regexp.Regexp
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+}
+
+// Probe sends the request and checks the response
+func (p HTTPProbe) Probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, timeoutOrDefault(p.Timeout))
+	defer cancel()
+
+	method := p.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	req, err := http.NewRequest(method, p.URL, strings.NewReader(p.Body))
+	if err != nil {
+		return err
+	}
+	for key, values := range p.Header {
+		req.Header[key] = values
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: p.InsecureSkipVerify},
+			DisableKeepAlives: true,
+		},
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if p.ExpectedStatus != 0 && resp.StatusCode != p.ExpectedStatus {
+		return fmt.Errorf("Unexpected status %d from %s, expected %d", resp.StatusCode, p.URL, p.ExpectedStatus)
+	}
+	if p.ExpectedStatus == 0 && (resp.StatusCode < 200 || resp.StatusCode > 299) {
+		return fmt.Errorf("Unexpected status %d from %s", resp.StatusCode, p.URL)
+	}
+
+	if p.BodyContains == "" && p.BodyPattern == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	if err != nil {
+		return err
+	}
+	if p.BodyContains != "" && !strings.Contains(string(body), p.BodyContains) {
+		return fmt.Errorf("Response from %s doesn't contain '%s'", p.URL, p.BodyContains)
+	}
+	if p.BodyPattern != nil && !p.BodyPattern.Match(body) {
+		return fmt.Errorf("Response from %s doesn't match %s", p.URL, p.BodyPattern)
+	}
+
+	return nil
+}
+
+// TLSProbe checks that a TLS handshake with the address succeeds and the certificate is valid long enough
+type TLSProbe struct {
+	Address string
+	// ServerName defaults to the host of the address
+	ServerName string
+	// MinValidity is the minimum remaining validity of the certificate
+	MinValidity        time.Duration
+	InsecureSkipVerify bool
+	Timeout            time.Duration
+}
+
+// Probe performs the handshake and checks the expiry of the certificate
+func (p TLSProbe) Probe(ctx context.Context) error {
+	serverName := p.ServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(p.Address)
+		if err != nil {
+			return err
+		}
+		serverName = host
+	}
+
+	conn, release, err := dial(ctx, "tcp", p.Address, p.Timeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: p.InsecureSkipVerify})
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+
+	certificates := tlsConn.ConnectionState().PeerCertificates
+	if len(certificates) == 0 {
+		return fmt.Errorf("No certificate received from %s", p.Address)
+	}
+	expiry := certificates[0].NotAfter
+	if expiry.Sub(time.Now()) < p.MinValidity {
+		return fmt.Errorf("Certificate of %s expires at %s", p.Address, expiry.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// LineProbe checks that a line based protocol answers as expected, e.g. the greeting "OK MPD" of MPD
+type LineProbe struct {
+	Network string
+	Address string
+	// Send is written to the connection before reading if not empty, it has to contain the line break
+	Send string
+	// Expect is required to be the beginning of the first received line
+	Expect  string
+	Timeout time.Duration
+}
+
+// Probe sends the line and checks the answer
+func (p LineProbe) Probe(ctx context.Context) error {
+	conn, release, err := dial(ctx, p.Network, p.Address, p.Timeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if p.Send != "" {
+		if _, err := io.WriteString(conn, p.Send); err != nil {
+			return err
+		}
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, p.Expect) {
+		return fmt.Errorf("Unexpected answer '%s' from %s, expected '%s'", strings.TrimSpace(line), p.Address, p.Expect)
+	}
+
+	return nil
+}
+
+// dial opens a connection which is bound to the timeout and the context
+//
+// The returned function closes the connection and has to be called when it is not used anymore.
+func dial(ctx context.Context, network string, address string, timeout time.Duration) (net.Conn, func(), error) {
+	ctx, cancel := context.WithTimeout(ctx, timeoutOrDefault(timeout))
+
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// interrupt pending reads and writes when the context is cancelled
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	release := func() {
+		close(stop)
+		cancel()
+		conn.Close()
+	}
+
+	return conn, release, nil
+}
+
+func timeoutOrDefault(timeout time.Duration) time.Duration {
+	if timeout > 0 {
+		return timeout
+	}
+
+	return DefaultTimeout
+}
@@ -0,0 +1,301 @@
+package statestore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// Backend represents a persistent storage for the states of a state store
+type Backend interface {
+	// Load returns all states which were persisted before
+	Load() (map[string]string, error)
+	// Save persists the given state
+	Save(name string, state string) error
+	// Delete removes the given state
+	Delete(name string) error
+	// Close releases all resources held by the backend
+	Close() error
+}
+
+// FileBackend persists states in an append-only log file which is compacted from time to time
+//
+// Every record is written with a checksum and synced to disk before Save returns.
+// A corrupted or truncated tail of the log, e.g. after a crash, is detected on Load and moved to a file with the suffix .corrupt.
+type FileBackend struct {
+	path    string
+	file    *os.File
+	states  map[string]string
+	records int
+	mutex   *sync.Mutex
+
+	// CompactThreshold is the minimum number of records in the log before it gets compacted
+	CompactThreshold int
+}
+
+type fileRecord struct {
+	Name    string `json:"name"`
+	State   string `json:"state,omitempty"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// NewFileBackend opens the log file at the given path, creating it if necessary
+func NewFileBackend(path string) (*FileBackend, error) {
+	// a leftover from an interrupted compaction is never complete, the log itself is still valid
+	os.Remove(path + ".tmp")
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileBackend{
+		path:             path,
+		file:             f,
+		states:           map[string]string{},
+		mutex:            &sync.Mutex{},
+		CompactThreshold: 1000,
+	}, nil
+}
+
+// Load reads all states from the log file, cutting off a corrupted tail
+//
+// The cut off bytes are appended to a file with the suffix .corrupt next to the log file.
+func (b *FileBackend) Load() (map[string]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(b.file)
+	if err != nil {
+		return nil, err
+	}
+
+	b.states = map[string]string{}
+	b.records = 0
+	offset := 0
+	for offset < len(data) {
+		end := bytes.IndexByte(data[offset:], '\n')
+		if end < 0 {
+			log.Printf("State log %s is truncated at offset %d, discarding %d bytes", b.path, offset, len(data)-offset)
+			break
+		}
+
+		r, err := decodeRecord(data[offset : offset+end])
+		if err != nil {
+			log.Printf("State log %s is corrupted at offset %d, discarding %d bytes: %s", b.path, offset, len(data)-offset, err)
+			break
+		}
+
+		if r.Deleted {
+			delete(b.states, r.Name)
+		} else {
+			b.states[r.Name] = r.State
+		}
+		b.records++
+		offset += end + 1
+	}
+
+	if offset < len(data) {
+		// keep the discarded bytes, a corrupted record could be followed by valid ones
+		if err := appendFileSync(b.path+".corrupt", data[offset:]); err != nil {
+			return nil, fmt.Errorf("Could not save corrupted tail of state log %s: %s", b.path, err)
+		}
+		if err := b.file.Truncate(int64(offset)); err != nil {
+			return nil, fmt.Errorf("Could not truncate corrupted state log %s: %s", b.path, err)
+		}
+		if err := b.file.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	states := make(map[string]string, len(b.states))
+	for name, state := range b.states {
+		states[name] = state
+	}
+
+	return states, nil
+}
+
+// Save appends the given state to the log file and syncs it to disk
+func (b *FileBackend) Save(name string, state string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.append(fileRecord{Name: name, State: state}); err != nil {
+		return err
+	}
+	b.states[name] = state
+	b.compactIfNecessary()
+
+	return nil
+}
+
+// Delete appends the deletion of the given state to the log file and syncs it to disk
+func (b *FileBackend) Delete(name string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.append(fileRecord{Name: name, Deleted: true}); err != nil {
+		return err
+	}
+	delete(b.states, name)
+	b.compactIfNecessary()
+
+	return nil
+}
+
+// Close closes the log file
+func (b *FileBackend) Close() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return b.file.Close()
+}
+
+func (b *FileBackend) append(r fileRecord) error {
+	line, err := encodeRecord(r)
+	if err != nil {
+		return err
+	}
+	if _, err := b.file.Write(line); err != nil {
+		return err
+	}
+	if err := b.file.Sync(); err != nil {
+		return err
+	}
+	b.records++
+
+	return nil
+}
+
+func (b *FileBackend) compactIfNecessary() {
+	if b.records >= b.CompactThreshold && b.records > 2*len(b.states) {
+		if err := b.compact(); err != nil {
+			log.Printf("Could not compact state log %s: %s", b.path, err)
+		}
+	}
+}
+
+// compact replaces the log with a new one containing only the current states
+func (b *FileBackend) compact() error {
+	tmpPath := b.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for name, state := range b.states {
+		line, err := encodeRecord(fileRecord{Name: name, State: state})
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return err
+		}
+		w.Write(line)
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(b.path))
+
+	f, err := os.OpenFile(b.path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	b.file.Close()
+	b.file = f
+	b.records = len(b.states)
+
+	return nil
+}
+
+func encodeRecord(r fileRecord) ([]byte, error) {
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+
+	line := make([]byte, 0, len(payload)+10)
+	line = append(line, fmt.Sprintf("%08x ", crc32.ChecksumIEEE(payload))...)
+	line = append(line, payload...)
+	line = append(line, '\n')
+
+	return line, nil
+}
+
+func decodeRecord(line []byte) (fileRecord, error) {
+	r := fileRecord{}
+
+	if len(line) < 9 || line[8] != ' ' {
+		return r, errors.New("Malformed record")
+	}
+	checksum, err := strconv.ParseUint(string(line[:8]), 16, 32)
+	if err != nil {
+		return r, fmt.Errorf("Malformed checksum: %s", err)
+	}
+	payload := line[9:]
+	if uint32(checksum) != crc32.ChecksumIEEE(payload) {
+		return r, errors.New("Checksum mismatch")
+	}
+	if err := json.Unmarshal(payload, &r); err != nil {
+		return r, err
+	}
+
+	return r, nil
+}
+
+func appendFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func syncDir(path string) {
+	d, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,143 @@
+package statestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func tempLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "statestore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "states.log"), func() { os.RemoveAll(dir) }
+}
+
+func writeStates(t *testing.T, path string, states ...string) {
+	b, err := NewFileBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	for i := 0; i+1 < len(states); i += 2 {
+		if err := b.Save(states[i], states[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func loadStates(t *testing.T, path string) map[string]string {
+	b, err := NewFileBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	states, err := b.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return states
+}
+
+func TestFileBackendTruncatedTail(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	writeStates(t, path, "a", "1", "b", "2")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cut off the line break and a part of the last record
+	if err := ioutil.WriteFile(path, data[:len(data)-5], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	states := loadStates(t, path)
+	if len(states) != 1 || states["a"] != "1" {
+		t.Fatalf("Unexpected states %v", states)
+	}
+
+	// the log has to be usable again after cutting off the tail
+	writeStates(t, path, "c", "3")
+	states = loadStates(t, path)
+	if len(states) != 2 || states["c"] != "3" {
+		t.Fatalf("Unexpected states %v after appending", states)
+	}
+}
+
+func TestFileBackendChecksumMismatch(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	writeStates(t, path, "a", "1", "b", "2", "c", "3")
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	lines[1] = strings.Replace(lines[1], `"2"`, `"9"`, 1)
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	states := loadStates(t, path)
+	if len(states) != 1 || states["a"] != "1" {
+		t.Fatalf("Unexpected states %v", states)
+	}
+
+	corrupt, err := ioutil.ReadFile(path + ".corrupt")
+	if err != nil {
+		t.Fatalf("Discarded tail was not kept: %s", err)
+	}
+	if string(corrupt) != lines[1]+lines[2] {
+		t.Fatalf("Unexpected discarded tail '%s'", corrupt)
+	}
+}
+
+func TestFileBackendCompaction(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	b, err := NewFileBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.CompactThreshold = 10
+	for i := 0; i < 25; i++ {
+		if err := b.Save("counter", strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Save("other", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Save("kept", "y"); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n >= 10 {
+		t.Fatalf("Log with %d records was not compacted", n)
+	}
+
+	states := loadStates(t, path)
+	if len(states) != 2 || states["counter"] != "24" || states["kept"] != "y" {
+		t.Fatalf("Unexpected states %v after compaction", states)
+	}
+}
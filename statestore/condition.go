@@ -0,0 +1,194 @@
+package statestore
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ErrTimeout is returned if waiting for a condition was aborted after the timeout
+var ErrTimeout = errors.New("Timeout while waiting for condition")
+
+// Matcher represents a predicate over a single state
+type Matcher func(state string) bool
+
+// Condition represents a predicate over the states of one or several names
+type Condition struct {
+	// Names contains all names the predicate depends on
+	Names []string
+	// Predicate is called with the current states of all names
+	Predicate func(states map[string]string) bool
+}
+
+// WaitResult describes which condition was met while waiting
+type WaitResult struct {
+	// Index is the position of the condition which was met
+	Index int
+	// States contains the states of the condition's names at the time it was met
+	States map[string]string
+}
+
+// Is returns a condition which is met when the given state matches
+func Is(name string, m Matcher) Condition {
+	return Condition{
+		Names: []string{name},
+		Predicate: func(states map[string]string) bool {
+			return m(states[name])
+		},
+	}
+}
+
+// All returns a condition which is met when all given conditions are met at the same time
+func All(conditions ...Condition) Condition {
+	names := []string{}
+	for _, c := range conditions {
+		names = append(names, c.Names...)
+	}
+
+	return Condition{
+		Names: names,
+		Predicate: func(states map[string]string) bool {
+			for _, c := range conditions {
+				if !c.Predicate(states) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+}
+
+// Any returns a condition which is met when at least one of the given conditions is met
+func Any(conditions ...Condition) Condition {
+	names := []string{}
+	for _, c := range conditions {
+		names = append(names, c.Names...)
+	}
+
+	return Condition{
+		Names: names,
+		Predicate: func(states map[string]string) bool {
+			for _, c := range conditions {
+				if c.Predicate(states) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+// Equals returns a matcher for the given state
+func Equals(value string) Matcher {
+	return func(state string) bool {
+		return state == value
+	}
+}
+
+// OneOf returns a matcher for any of the given states
+func OneOf(values ...string) Matcher {
+	return func(state string) bool {
+		for _, v := range values {
+			if state == v {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a matcher which inverts the given one
+func Not(m Matcher) Matcher {
+	return func(state string) bool {
+		return !m(state)
+	}
+}
+
+// GreaterThan returns a matcher for numeric states greater than the given value
+func GreaterThan(value float64) Matcher {
+	return func(state string) bool {
+		v, err := strconv.ParseFloat(state, 64)
+		return err == nil && v > value
+	}
+}
+
+// LessThan returns a matcher for numeric states less than the given value
+func LessThan(value float64) Matcher {
+	return func(state string) bool {
+		v, err := strconv.ParseFloat(state, 64)
+		return err == nil && v < value
+	}
+}
+
+// WaitUntil waits for one of the given conditions to be met, aborting after the timeout or when the context is done
+//
+// A timeout of zero or less waits until the context is done.
+// The result contains the first condition which was met and the states it was evaluated with.
+func (s *StateStore) WaitUntil(ctx context.Context, timeout time.Duration, conditions ...Condition) (WaitResult, error) {
+	parent := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(parent, timeout)
+		defer cancel()
+	}
+
+	names := conditionNames(conditions)
+	w := s.watch(names, nil, nil)
+	defer w.Close()
+
+	for {
+		if result, ok := s.evaluate(names, conditions); ok {
+			return result, nil
+		}
+
+		select {
+		case <-w.C:
+		case <-ctx.Done():
+			if err := parent.Err(); err != nil {
+				return WaitResult{Index: -1}, err
+			}
+			return WaitResult{Index: -1}, ErrTimeout
+		}
+	}
+}
+
+// evaluate checks the conditions in order against a consistent view of the states
+func (s *StateStore) evaluate(names []string, conditions []Condition) (WaitResult, bool) {
+	states := make(map[string]string, len(names))
+
+	s.mutex.RLock()
+	for _, name := range names {
+		states[name] = s.states[name]
+	}
+	s.mutex.RUnlock()
+
+	for i, c := range conditions {
+		if !c.Predicate(states) {
+			continue
+		}
+
+		result := WaitResult{Index: i, States: map[string]string{}}
+		for _, name := range c.Names {
+			result.States[name] = states[name]
+		}
+		return result, true
+	}
+
+	return WaitResult{Index: -1}, false
+}
+
+func conditionNames(conditions []Condition) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, c := range conditions {
+		for _, name := range c.Names {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+
+	return names
+}
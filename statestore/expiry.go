@@ -0,0 +1,139 @@
+package statestore
+
+import (
+	"container/heap"
+	"time"
+)
+
+type expiry struct {
+	name         string
+	deadline     time.Time
+	defaultState string
+	remove       bool
+	index        int
+}
+
+// expiryQueue orders the expiries by their deadline, the earliest comes first
+type expiryQueue []*expiry
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].deadline.Before(q[j].deadline) }
+
+func (q expiryQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *expiryQueue) Push(x interface{}) {
+	e := x.(*expiry)
+	e.index = len(*q)
+	*q = append(*q, e)
+}
+
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*q = old[:len(old)-1]
+	e.index = -1
+
+	return e
+}
+
+// StoreTTL saves the given state, which is deleted after the TTL, and returns the old state
+//
+// Storing the state again before the TTL elapsed replaces the expiry.
+// The expiry is delivered to watchers and waiters like any other change.
+// A backend persists the state as already expired, so it is missing after a restart.
+func (s *StateStore) StoreTTL(name string, state string, ttl time.Duration) (oldState string, changed bool) {
+	c := s.storeTTL(name, state, ttl, "", true)
+
+	return c.OldState, c.Kind != Unchanged
+}
+
+// StoreTTLDefault saves the given state, which reverts to the default state after the TTL, and returns the old state
+//
+// A backend persists the default state, so the state is reverted after a restart.
+func (s *StateStore) StoreTTLDefault(name string, state string, ttl time.Duration, defaultState string) (oldState string, changed bool) {
+	c := s.storeTTL(name, state, ttl, defaultState, false)
+
+	return c.OldState, c.Kind != Unchanged
+}
+
+func (s *StateStore) storeTTL(name string, state string, ttl time.Duration, defaultState string, remove bool) Change {
+	now := time.Now()
+
+	s.mutex.Lock()
+	// the expiry can't be restored, so the state is persisted as if it already expired
+	c := s.apply(name, state, now)
+	if remove {
+		s.unsave(name)
+	} else {
+		s.save(name, defaultState)
+	}
+	s.scheduleExpiry(&expiry{name: name, deadline: now.Add(ttl), defaultState: defaultState, remove: remove})
+	s.unlockAndNotify([]Change{c})
+
+	return c
+}
+
+// scheduleExpiry sets or replaces the expiry of the state, the caller has to hold the write lock
+func (s *StateStore) scheduleExpiry(e *expiry) {
+	if old, ok := s.expiryIndex[e.name]; ok {
+		heap.Remove(&s.expiries, old.index)
+	}
+	heap.Push(&s.expiries, e)
+	s.expiryIndex[e.name] = e
+
+	s.resetExpiryTimer()
+}
+
+// cancelExpiry removes the expiry of the given state if there is one, the caller has to hold the write lock
+func (s *StateStore) cancelExpiry(name string) {
+	e, ok := s.expiryIndex[name]
+	if !ok {
+		return
+	}
+
+	heap.Remove(&s.expiries, e.index)
+	delete(s.expiryIndex, name)
+	s.resetExpiryTimer()
+}
+
+// resetExpiryTimer arms the single timer for the earliest expiry, the caller has to hold the write lock
+func (s *StateStore) resetExpiryTimer() {
+	if len(s.expiries) == 0 {
+		if s.expiryTimer != nil {
+			s.expiryTimer.Stop()
+		}
+		return
+	}
+
+	d := s.expiries[0].deadline.Sub(time.Now())
+	if s.expiryTimer == nil {
+		s.expiryTimer = time.AfterFunc(d, s.expire)
+		return
+	}
+	s.expiryTimer.Stop()
+	s.expiryTimer.Reset(d)
+}
+
+// expire reverts or deletes all states whose TTL elapsed
+func (s *StateStore) expire() {
+	now := time.Now()
+	changes := []Change{}
+
+	s.mutex.Lock()
+	for len(s.expiries) > 0 && !s.expiries[0].deadline.After(now) {
+		e := heap.Pop(&s.expiries).(*expiry)
+		delete(s.expiryIndex, e.name)
+		if !e.remove {
+			changes = append(changes, s.set(e.name, e.defaultState, now))
+		} else if c, ok := s.remove(e.name, now); ok {
+			changes = append(changes, c)
+		}
+	}
+	s.resetExpiryTimer()
+	s.unlockAndNotify(changes)
+}
@@ -0,0 +1,66 @@
+package statestore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStoreTTL(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+
+	s.StoreTTL("motion", "on", 10*time.Millisecond)
+	s.StoreTTLDefault("scene", "movie", 10*time.Millisecond, "off")
+	s.StoreTTL("door", "open", 10*time.Millisecond)
+	s.Store("door", "closed")
+
+	if !s.WaitFor("scene", "off", time.Second) {
+		t.Fatal("State with default didn't revert")
+	}
+	if !s.WaitFor("motion", "", time.Second) {
+		t.Fatal("State didn't expire")
+	}
+	if _, ok := s.lookup("motion"); ok {
+		t.Fatal("Expired state is still stored")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if state := s.Get("door"); state != "closed" {
+		t.Fatalf("State which was stored again expired to '%s'", state)
+	}
+}
+
+func TestStoreTTLPersistence(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	b, err := NewFileBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewStateStore(WithBackend(b))
+	s.Store("motion", "off")
+	s.StoreTTL("motion", "on", time.Hour)
+	s.StoreTTLDefault("scene", "movie", time.Hour, "off")
+	s.StoreTTL("light", "on", time.Hour)
+	// storing the same state again without TTL makes it permanent
+	s.Store("light", "on")
+	s.Close()
+
+	b, err = NewFileBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = NewStateStore(WithBackend(b))
+	defer s.Close()
+
+	if state, ok := s.lookup("motion"); ok {
+		t.Fatalf("State with TTL was restored as '%s'", state)
+	}
+	if state := s.Get("scene"); state != "off" {
+		t.Fatalf("State with default was restored as '%s'", state)
+	}
+	if state := s.Get("light"); state != "on" {
+		t.Fatalf("Permanent state was restored as '%s'", state)
+	}
+}
@@ -0,0 +1,110 @@
+package statestore
+
+import (
+	"time"
+)
+
+// Retention defines how much history is kept for a state
+type Retention struct {
+	// MaxEntries is the maximum number of kept changes, zero disables the history
+	MaxEntries int
+	// MaxAge is the maximum age of kept changes, zero keeps them regardless of their age
+	MaxAge time.Duration
+}
+
+// HistoryEntry represents a state which was stored at some point in time
+type HistoryEntry struct {
+	State string    `json:"state"`
+	Time  time.Time `json:"time"`
+}
+
+// WithHistory records the changes of all states with the given retention
+func WithHistory(r Retention) Option {
+	return func(s *StateStore) {
+		s.retention = r
+	}
+}
+
+// SetRetention overrides the retention of the history for the given state
+func (s *StateStore) SetRetention(name string, r Retention) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.retentions[name] = r
+	s.history[name] = prune(s.history[name], r, time.Now())
+	if len(s.history[name]) == 0 {
+		delete(s.history, name)
+	}
+}
+
+// LastChanged returns the time the given state was changed the last time
+//
+// The result is false if the state did not change since the store was created.
+func (s *StateStore) LastChanged(name string) (time.Time, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	t, ok := s.lastChanged[name]
+
+	return t, ok
+}
+
+// History returns the recorded changes of the given state between from and to, both inclusive
+func (s *StateStore) History(name string, from time.Time, to time.Time) []HistoryEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entries := []HistoryEntry{}
+	for _, e := range s.history[name] {
+		if !e.Time.Before(from) && !e.Time.After(to) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+// Since returns the recorded changes of the given state within the given duration up to now
+func (s *StateStore) Since(name string, d time.Duration) []HistoryEntry {
+	now := time.Now()
+
+	return s.History(name, now.Add(-d), now)
+}
+
+// recordChange remembers the change of the given state, the caller has to hold the write lock
+func (s *StateStore) recordChange(name string, state string, t time.Time) {
+	s.lastChanged[name] = t
+
+	r, ok := s.retentions[name]
+	if !ok {
+		r = s.retention
+	}
+	if r.MaxEntries <= 0 {
+		return
+	}
+
+	s.history[name] = prune(append(s.history[name], HistoryEntry{State: state, Time: t}), r, t)
+}
+
+// prune removes all entries which exceed the retention
+func prune(entries []HistoryEntry, r Retention, now time.Time) []HistoryEntry {
+	if r.MaxEntries <= 0 {
+		return nil
+	}
+
+	start := 0
+	if len(entries) > r.MaxEntries {
+		start = len(entries) - r.MaxEntries
+	}
+	if r.MaxAge > 0 {
+		limit := now.Add(-r.MaxAge)
+		for start < len(entries) && entries[start].Time.Before(limit) {
+			start++
+		}
+	}
+	if start == 0 {
+		return entries
+	}
+
+	return append([]HistoryEntry{}, entries[start:]...)
+}
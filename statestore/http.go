@@ -0,0 +1,173 @@
+package statestore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const maxStateSize = 1 << 20
+
+// StateInfo describes a single state served by the HTTP handler
+type StateInfo struct {
+	Name        string     `json:"name"`
+	State       string     `json:"state"`
+	LastChanged *time.Time `json:"lastchanged,omitempty"`
+}
+
+type handler struct {
+	store *StateStore
+}
+
+// NewHandler returns an HTTP handler to inspect and control the state store
+//
+// It can be mounted on any http.ServeMux, e.g. with http.StripPrefix, and serves the following routes:
+//
+//	GET    /states          lists all states, the query parameter pattern filters them with the wildcards + and #
+//	GET    /states/{name}   returns a single state
+//	PUT    /states/{name}   stores the request body as state
+//	DELETE /states/{name}   deletes the state
+//	GET    /events          streams the changes as server-sent events, also filtered by the query parameter pattern
+func NewHandler(s *StateStore) http.Handler {
+	h := &handler{store: s}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/states", h.handleList)
+	mux.HandleFunc("/states/", h.handleState)
+	mux.HandleFunc("/events", h.handleEvents)
+
+	return mux
+}
+
+func (h *handler) handleList(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	states := []StateInfo{}
+	for _, name := range h.store.Keys(patternParameter(r)) {
+		if info, ok := h.info(name); ok {
+			states = append(states, info)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, states)
+}
+
+func (h *handler) handleState(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/states/")
+	if name == "" {
+		http.Error(w, "Name of state is missing", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		info, ok := h.info(name)
+		if !ok {
+			http.Error(w, fmt.Sprintf("State %s is not stored", name), http.StatusNotFound)
+			return
+		}
+		writeJSON(w, http.StatusOK, info)
+	case http.MethodPut:
+		b, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxStateSize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Could not read state: %s", err), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Storing state %s with value '%s' through HTTP", name, b)
+		writeJSON(w, http.StatusOK, h.store.Set(name, string(b)))
+	case http.MethodDelete:
+		if _, ok := h.store.Delete(name); !ok {
+			http.Error(w, fmt.Sprintf("State %s is not stored", name), http.StatusNotFound)
+			return
+		}
+		log.Printf("Deleted state %s through HTTP", name)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodPut, http.MethodDelete}, ", "))
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	watcher := h.store.Watch(patternParameter(r), WithBuffer(256))
+	defer watcher.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case c, ok := <-watcher.C:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(c)
+			if err != nil {
+				log.Printf("Could not marshal change of state %s: %s", c.Name, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", c.Kind, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (h *handler) info(name string) (StateInfo, bool) {
+	state, ok := h.store.lookup(name)
+	if !ok {
+		return StateInfo{}, false
+	}
+
+	info := StateInfo{Name: name, State: state}
+	if t, ok := h.store.LastChanged(name); ok {
+		info.LastChanged = &t
+	}
+
+	return info, true
+}
+
+func patternParameter(r *http.Request) string {
+	if pattern := r.URL.Query().Get("pattern"); pattern != "" {
+		return pattern
+	}
+
+	return "#"
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not marshal response: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
@@ -0,0 +1,177 @@
+package statestore
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serve(h http.Handler, method string, target string, body io.Reader) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(method, target, body))
+
+	return w
+}
+
+func TestHandlerList(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+	s.Store("lr/tv/power", "on")
+	s.Store("lr/amp/power", "off")
+	s.Store("kitchen/light", "on")
+	h := NewHandler(s)
+
+	tests := []struct {
+		target string
+		names  []string
+	}{
+		{"/states", []string{"kitchen/light", "lr/amp/power", "lr/tv/power"}},
+		{"/states?pattern=lr/%23", []string{"lr/amp/power", "lr/tv/power"}},
+		{"/states?pattern=%2B/tv/power", []string{"lr/tv/power"}},
+		{"/states?pattern=bath/%23", []string{}},
+	}
+
+	for _, test := range tests {
+		w := serve(h, http.MethodGet, test.target, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s returned status %d", test.target, w.Code)
+		}
+		states := []StateInfo{}
+		if err := json.Unmarshal(w.Body.Bytes(), &states); err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, info := range states {
+			names = append(names, info.Name)
+			if info.State != s.Get(info.Name) || info.LastChanged == nil {
+				t.Errorf("Unexpected state info %v", info)
+			}
+		}
+		if strings.Join(names, ",") != strings.Join(test.names, ",") {
+			t.Errorf("GET %s returned %v instead of %v", test.target, names, test.names)
+		}
+	}
+
+	if w := serve(h, http.MethodPost, "/states", nil); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != http.MethodGet {
+		t.Errorf("POST /states returned status %d", w.Code)
+	}
+}
+
+func TestHandlerState(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+	h := NewHandler(s)
+
+	if w := serve(h, http.MethodGet, "/states/lr/tv/power", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("GET of a missing state returned status %d", w.Code)
+	}
+
+	w := serve(h, http.MethodPut, "/states/lr/tv/power", strings.NewReader("on"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT returned status %d", w.Code)
+	}
+	c := Change{}
+	if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Name != "lr/tv/power" || c.Kind != Created || c.NewState != "on" {
+		t.Fatalf("PUT returned unexpected change %v", c)
+	}
+	if state := s.Get("lr/tv/power"); state != "on" {
+		t.Fatalf("PUT stored state '%s'", state)
+	}
+
+	w = serve(h, http.MethodGet, "/states/lr/tv/power", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET returned status %d", w.Code)
+	}
+	info := StateInfo{}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Name != "lr/tv/power" || info.State != "on" || info.LastChanged == nil {
+		t.Fatalf("GET returned unexpected state info %v", info)
+	}
+
+	if w := serve(h, http.MethodDelete, "/states/lr/tv/power", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE returned status %d", w.Code)
+	}
+	if _, ok := s.lookup("lr/tv/power"); ok {
+		t.Fatal("DELETE didn't delete the state")
+	}
+	if w := serve(h, http.MethodDelete, "/states/lr/tv/power", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("DELETE of a missing state returned status %d", w.Code)
+	}
+
+	if w := serve(h, http.MethodGet, "/states/", nil); w.Code != http.StatusNotFound {
+		t.Fatalf("GET without name returned status %d", w.Code)
+	}
+	if w := serve(h, http.MethodPost, "/states/lr/tv/power", nil); w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") == "" {
+		t.Fatalf("POST returned status %d", w.Code)
+	}
+}
+
+func TestHandlerEvents(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/", http.StripPrefix("/debug", NewHandler(s)))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/debug/events?pattern=lr/%2B/power")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Events returned status %d with content type %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	// the watcher is registered before the headers are sent, so the changes can't be missed
+	s.Set("kitchen/light", "on")
+	s.Set("lr/tv/power", "on")
+
+	lines := make(chan string)
+	go func() {
+		r := bufio.NewReader(resp.Body)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				close(lines)
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	expected := []string{"event: created\n", "data: ", "\n"}
+	for _, e := range expected {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("Event stream was closed")
+			}
+			if !strings.HasPrefix(line, e) {
+				t.Fatalf("Unexpected line '%s' instead of '%s'", line, e)
+			}
+			if e == "data: " {
+				c := Change{}
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, e)), &c); err != nil {
+					t.Fatal(err)
+				}
+				if c.Name != "lr/tv/power" || c.NewState != "on" {
+					t.Fatalf("Unexpected change %v", c)
+				}
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Event was not received")
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package statestore
+
+import (
+	"sort"
+	"strings"
+)
+
+// keyIndex organizes the names of the states in a tree of their slash-separated levels
+//
+// This allows to resolve patterns with the MQTT wildcards + and # without looking at every name.
+type keyIndex struct {
+	root *indexNode
+}
+
+type indexNode struct {
+	children map[string]*indexNode
+	leaf     bool
+}
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{root: newIndexNode()}
+}
+
+func newIndexNode() *indexNode {
+	return &indexNode{children: map[string]*indexNode{}}
+}
+
+func (i *keyIndex) insert(name string) {
+	n := i.root
+	for _, level := range strings.Split(name, "/") {
+		child, ok := n.children[level]
+		if !ok {
+			child = newIndexNode()
+			n.children[level] = child
+		}
+		n = child
+	}
+	n.leaf = true
+}
+
+func (i *keyIndex) remove(name string) {
+	removeLevels(i.root, strings.Split(name, "/"))
+}
+
+// removeLevels unmarks the leaf and prunes nodes without any names below, it returns whether the node became empty
+func removeLevels(n *indexNode, levels []string) bool {
+	if len(levels) == 0 {
+		n.leaf = false
+	} else if child, ok := n.children[levels[0]]; ok && removeLevels(child, levels[1:]) {
+		delete(n.children, levels[0])
+	}
+
+	return !n.leaf && len(n.children) == 0
+}
+
+// match returns all names matching the pattern in sorted order
+func (i *keyIndex) match(pattern string) []string {
+	names := []string{}
+	collectMatches(i.root, strings.Split(pattern, "/"), nil, &names)
+	sort.Strings(names)
+
+	return names
+}
+
+func collectMatches(n *indexNode, levels []string, path []string, names *[]string) {
+	if len(levels) == 0 {
+		if n.leaf {
+			*names = append(*names, strings.Join(path, "/"))
+		}
+		return
+	}
+
+	switch levels[0] {
+	case "#":
+		// like in MQTT the multi-level wildcard also matches the parent level
+		if n.leaf && len(path) > 0 {
+			*names = append(*names, strings.Join(path, "/"))
+		}
+		for level, child := range n.children {
+			collectSubtree(child, append(path, level), names)
+		}
+	case "+":
+		for level, child := range n.children {
+			collectMatches(child, levels[1:], append(path, level), names)
+		}
+	default:
+		if child, ok := n.children[levels[0]]; ok {
+			collectMatches(child, levels[1:], append(path, levels[0]), names)
+		}
+	}
+}
+
+func collectSubtree(n *indexNode, path []string, names *[]string) {
+	if n.leaf {
+		*names = append(*names, strings.Join(path, "/"))
+	}
+	for level, child := range n.children {
+		collectSubtree(child, append(path, level), names)
+	}
+}
+
+// isPattern returns whether the name contains one of the MQTT wildcards + or #
+func isPattern(name string) bool {
+	for _, level := range strings.Split(name, "/") {
+		if level == "+" || level == "#" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPattern checks a single name against a pattern with the MQTT wildcards + and #
+func matchPattern(pattern string, name string) bool {
+	patternLevels := strings.Split(pattern, "/")
+	nameLevels := strings.Split(name, "/")
+
+	for i, p := range patternLevels {
+		if p == "#" {
+			return true
+		}
+		if i >= len(nameLevels) {
+			return false
+		}
+		if p != "+" && p != nameLevels[i] {
+			return false
+		}
+	}
+
+	return len(patternLevels) == len(nameLevels)
+}
@@ -0,0 +1,86 @@
+package statestore
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Keys returns the names of all states matching the pattern in sorted order
+//
+// Names are treated as slash-separated levels like MQTT topics,
+// so the pattern may contain the wildcards + and #, e.g. livingroom/# matches the whole subtree.
+func (s *StateStore) Keys(pattern string) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.index.match(pattern)
+}
+
+// GetMatching returns all states whose name matches the pattern
+func (s *StateStore) GetMatching(pattern string) map[string]string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	states := map[string]string{}
+	for _, name := range s.index.match(pattern) {
+		states[name] = s.states[name]
+	}
+
+	return states
+}
+
+// DeleteMatching removes all states whose name matches the pattern and returns the changes
+func (s *StateStore) DeleteMatching(pattern string) []Change {
+	now := time.Now()
+	changes := []Change{}
+
+	s.mutex.Lock()
+	for _, name := range s.index.match(pattern) {
+		if c, ok := s.remove(name, now); ok {
+			changes = append(changes, c)
+		}
+	}
+	s.unlockAndNotify(changes)
+
+	return changes
+}
+
+// WaitForMatch waits for any state matching the pattern to have the given value, aborting after the timeout
+//
+// It returns the name of the first matching state.
+func (s *StateStore) WaitForMatch(pattern string, state string, timeout time.Duration) (string, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	name, err := s.WaitForMatchContext(ctx, pattern, state)
+	if err != nil {
+		log.Printf("Abort waiting for any state matching %s to be %s after %s", pattern, state, timeout)
+		return "", false
+	}
+
+	return name, true
+}
+
+// WaitForMatchContext waits for any state matching the pattern to have the given value, aborting when the context is done
+func (s *StateStore) WaitForMatchContext(ctx context.Context, pattern string, state string) (string, error) {
+	w := s.Watch(pattern)
+	defer w.Close()
+
+	for _, name := range s.Keys(pattern) {
+		if s.Get(name) == state {
+			return name, nil
+		}
+	}
+
+	for {
+		select {
+		case c := <-w.C:
+			if c.NewState == state {
+				return c.Name, nil
+			}
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package statestore
+
+import (
+	"sort"
+	"time"
+)
+
+// Snapshot represents all states of a state store at a point in time
+type Snapshot struct {
+	Time   time.Time         `json:"time"`
+	States map[string]string `json:"states"`
+}
+
+// Snapshot returns a copy of all current states
+func (s *StateStore) Snapshot() Snapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	snapshot := Snapshot{
+		Time:   time.Now(),
+		States: make(map[string]string, len(s.states)),
+	}
+	for name, state := range s.states {
+		snapshot.States[name] = state
+	}
+
+	return snapshot
+}
+
+// Diff returns the changes which lead from snapshot a to snapshot b, ordered by name
+//
+// States which are missing in b are reported as deleted, states missing in a as created.
+func Diff(a Snapshot, b Snapshot) []Change {
+	names := []string{}
+	for name := range a.States {
+		names = append(names, name)
+	}
+	for name := range b.States {
+		if _, ok := a.States[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	changes := []Change{}
+	for _, name := range names {
+		oldState, inA := a.States[name]
+		newState, inB := b.States[name]
+		if inA && inB && oldState == newState {
+			continue
+		}
+		c := Change{Name: name, Kind: Updated, OldState: oldState, NewState: newState, Time: b.Time}
+		if !inA {
+			c.Kind = Created
+		} else if !inB {
+			c.Kind = Deleted
+		}
+		changes = append(changes, c)
+	}
+
+	return changes
+}
+
+// Restore sets all states to the ones of the snapshot and returns the applied changes
+//
+// States which are not part of the snapshot are deleted, pending expiries of changed states are cancelled.
+// All changes are applied at once and delivered to the watchers afterwards without any other change in between.
+func (s *StateStore) Restore(snapshot Snapshot) []Change {
+	now := time.Now()
+	changes := []Change{}
+
+	s.mutex.Lock()
+	current := Snapshot{States: s.states}
+	for _, c := range Diff(current, snapshot) {
+		if c.Kind == Deleted {
+			if c, ok := s.remove(c.Name, now); ok {
+				changes = append(changes, c)
+			}
+			continue
+		}
+		changes = append(changes, s.set(c.Name, c.NewState, now))
+	}
+	s.unlockAndNotify(changes)
+
+	return changes
+}
@@ -1,148 +1,297 @@
 package statestore
 
 import (
+	"context"
 	"log"
 	"sync"
 	"time"
 )
 
 // StateStore represents a storage for the state
+//
+// All methods are safe for concurrent use.
+// The states are guarded by a read-write lock, watchers are notified after it has been released.
+// Notifications are serialized by a separate lock, so every watcher receives the changes in the order they were stored.
 type StateStore struct {
-	states  map[string]string
-	updates map[string][]chan string
-	mutex   *sync.Mutex
+	states        map[string]string
+	index         *keyIndex
+	watchers      map[string][]*Watcher
+	matchWatchers []*Watcher
+	mutex         *sync.RWMutex
+	notifyMutex   *sync.Mutex
+	backend       Backend
+	lastChanged   map[string]time.Time
+	history       map[string][]HistoryEntry
+	retention     Retention
+	retentions    map[string]Retention
+	expiries      expiryQueue
+	expiryIndex   map[string]*expiry
+	expiryTimer   *time.Timer
+}
+
+// Option represents a configuration option for a state store
+type Option func(*StateStore)
+
+// WithBackend persists all states through the given backend and restores them on creation
+func WithBackend(b Backend) Option {
+	return func(s *StateStore) {
+		s.backend = b
+	}
 }
 
 // NewStateStore creates a new state store
-func NewStateStore() *StateStore {
-	return &StateStore{
-		states:  map[string]string{},
-		updates: map[string][]chan string{},
-		mutex:   &sync.Mutex{},
+func NewStateStore(options ...Option) *StateStore {
+	s := &StateStore{
+		states:      map[string]string{},
+		index:       newKeyIndex(),
+		watchers:    map[string][]*Watcher{},
+		mutex:       &sync.RWMutex{},
+		notifyMutex: &sync.Mutex{},
+		lastChanged: map[string]time.Time{},
+		history:     map[string][]HistoryEntry{},
+		retentions:  map[string]Retention{},
+		expiryIndex: map[string]*expiry{},
+	}
+
+	for _, o := range options {
+		o(s)
+	}
+
+	if s.backend != nil {
+		states, err := s.backend.Load()
+		if err != nil {
+			log.Printf("Could not restore states: %s", err)
+		}
+		for name, state := range states {
+			s.states[name] = state
+			s.index.insert(name)
+		}
+	}
+
+	return s
+}
+
+// Close stops pending expiries and releases the backend of the state store
+func (s *StateStore) Close() error {
+	s.mutex.Lock()
+	if s.expiryTimer != nil {
+		s.expiryTimer.Stop()
+	}
+	s.mutex.Unlock()
+
+	if s.backend == nil {
+		return nil
 	}
+
+	return s.backend.Close()
 }
 
 // Store saves the given state and returns the old state
+//
+// The result is also changed if the state was not stored before.
 func (s *StateStore) Store(name string, state string) (oldState string, changed bool) {
-	oldState, _ = s.states[name]
+	c := s.Set(name, state)
+
+	return c.OldState, c.Kind != Unchanged
+}
 
+// Set saves the given state and returns the resulting change
+func (s *StateStore) Set(name string, state string) Change {
 	s.mutex.Lock()
-	s.states[name] = state
-	s.mutex.Unlock()
+	c := s.set(name, state, time.Now())
+	s.unlockAndNotify([]Change{c})
 
-	if oldState == "" {
-		changed = false
-	} else {
-		changed = oldState != state
-	}
+	return c
+}
 
-	if channels, ok := s.updates[name]; ok {
-		for _, ch := range channels {
-			ch <- state
-		}
+// Delete removes the given state and returns the old state
+func (s *StateStore) Delete(name string) (oldState string, deleted bool) {
+	s.mutex.Lock()
+	c, deleted := s.remove(name, time.Now())
+	if !deleted {
+		s.mutex.Unlock()
+		return "", false
 	}
+	s.unlockAndNotify([]Change{c})
 
-	return
+	return c.OldState, true
 }
 
 // Get returns the given state if available, otherwise an empty string
 func (s *StateStore) Get(name string) string {
-	if s, ok := s.states[name]; ok {
-		return s
-	}
+	state, _ := s.lookup(name)
+
+	return state
+}
+
+// lookup returns the given state and whether it is stored at all
+func (s *StateStore) lookup(name string) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	state, ok := s.states[name]
 
-	return ""
+	return state, ok
 }
 
 // WaitFor waits for the given state to be stored, aborting after the timeout
 func (s *StateStore) WaitFor(name string, state string, timeout time.Duration) bool {
-	if s.Get(name) == state {
-		return true
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.WaitForContext(ctx, name, state); err != nil {
+		log.Printf("Abort waiting for state %s to be %s after %s", name, state, timeout)
+		return false
 	}
 
-	after := time.After(timeout)
-	updates := s.registerUpdateChannel(name)
-	done := false
-	result := false
+	return true
+}
+
+// WaitForContext waits for the given state to be stored, aborting when the context is done
+func (s *StateStore) WaitForContext(ctx context.Context, name string, state string) error {
+	w := s.Watch(name)
+	defer w.Close()
 
-	for !done {
+	if s.Get(name) == state {
+		return nil
+	}
+
+	for {
 		select {
-		case changed := <-updates:
-			if changed == state {
-				result = true
-				done = true
+		case c := <-w.C:
+			if c.NewState == state {
+				return nil
 			}
-		case <-after:
-			log.Printf("Abort waiting for state %s to be %s after %s", name, state, timeout)
-			done = true
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
-
-	s.unregisterUpdateChannel(name, updates)
-
-	return result
 }
 
 // WaitForNot waits for the given state to have a different value, aborting after the timeout
 func (s *StateStore) WaitForNot(name string, state string, timeout time.Duration) bool {
-	if s.Get(name) != state {
-		return true
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := s.WaitForNotContext(ctx, name, state); err != nil {
+		log.Printf("Abort waiting for state %s not to be %s after %s", name, state, timeout)
+		return false
 	}
 
-	after := time.After(timeout)
-	updates := s.registerUpdateChannel(name)
-	done := false
-	result := false
+	return true
+}
+
+// WaitForNotContext waits for the given state to have a different value, aborting when the context is done
+func (s *StateStore) WaitForNotContext(ctx context.Context, name string, state string) error {
+	w := s.Watch(name)
+	defer w.Close()
 
-	for !done {
+	if s.Get(name) != state {
+		return nil
+	}
+
+	for {
 		select {
-		case changed := <-updates:
-			if changed != state {
-				result = true
-				done = true
+		case c := <-w.C:
+			if c.NewState != state {
+				return nil
 			}
-		case <-after:
-			log.Printf("Abort waiting for state %s not to be %s after %s", name, state, timeout)
-			done = true
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
+}
 
-	s.unregisterUpdateChannel(name, updates)
+// set saves and persists the given state without expiry and records the change, the caller has to hold the write lock
+func (s *StateStore) set(name string, state string, t time.Time) Change {
+	_, expiring := s.expiryIndex[name]
+	s.cancelExpiry(name)
 
-	return result
+	c := s.apply(name, state, t)
+	// a state with an expiry was persisted as already expired, so it has to be persisted again even if it didn't change
+	if c.Kind != Unchanged || expiring {
+		s.save(name, state)
+	}
+
+	return c
 }
 
-func (s *StateStore) registerUpdateChannel(name string) chan string {
-	ch := make(chan string)
+// apply saves the given state without persisting it and records the change, the caller has to hold the write lock
+func (s *StateStore) apply(name string, state string, t time.Time) Change {
+	oldState, existed := s.states[name]
+	c := Change{Name: name, Kind: Updated, OldState: oldState, NewState: state, Time: t}
+	if !existed {
+		c.Kind = Created
+	} else if oldState == state {
+		c.Kind = Unchanged
+		return c
+	}
 
-	s.mutex.Lock()
-	if _, ok := s.updates[name]; !ok {
-		s.updates[name] = []chan string{}
+	s.states[name] = state
+	if !existed {
+		s.index.insert(name)
 	}
-	s.updates[name] = append(s.updates[name], ch)
-	s.mutex.Unlock()
+	s.recordChange(name, state, t)
 
-	return ch
+	return c
 }
 
-func (s *StateStore) unregisterUpdateChannel(name string, ch chan string) {
-	if _, ok := s.updates[name]; !ok {
+// save persists the given state if there is a backend, the caller has to hold the write lock
+func (s *StateStore) save(name string, state string) {
+	if s.backend == nil {
 		return
 	}
+	if err := s.backend.Save(name, state); err != nil {
+		log.Printf("Could not persist state %s: %s", name, err)
+	}
+}
 
-	channels := []chan string{}
+// unsave deletes the given state from the backend if there is one, the caller has to hold the write lock
+func (s *StateStore) unsave(name string) {
+	if s.backend == nil {
+		return
+	}
+	if err := s.backend.Delete(name); err != nil {
+		log.Printf("Could not delete persisted state %s: %s", name, err)
+	}
+}
 
-	s.mutex.Lock()
-	for _, registeredChannel := range s.updates[name] {
-		if registeredChannel != ch {
-			channels = append(channels, registeredChannel)
-		}
+// remove deletes the given state and records the change, the caller has to hold the write lock
+func (s *StateStore) remove(name string, t time.Time) (Change, bool) {
+	oldState, existed := s.states[name]
+	if !existed {
+		return Change{}, false
 	}
-	if len(channels) > 0 {
-		s.updates[name] = channels
-	} else {
-		delete(s.updates, name)
+
+	delete(s.states, name)
+	s.index.remove(name)
+	s.cancelExpiry(name)
+	s.unsave(name)
+	s.recordChange(name, "", t)
+
+	return Change{Name: name, Kind: Deleted, OldState: oldState, NewState: "", Time: t}, true
+}
+
+// unlockAndNotify releases the write lock, which the caller has to hold, and delivers the changes to all interested watchers
+//
+// Unchanged states are only delivered to watchers which asked for every write.
+// The watchers are collected while still holding the write lock and the notification lock is acquired before releasing it.
+// This way no other change can be delivered in between, although the states are already accessible again.
+func (s *StateStore) unlockAndNotify(changes []Change) {
+	deliveries := make([][]*Watcher, len(changes))
+	for i, c := range changes {
+		deliveries[i] = s.matchingWatchers(c.Name)
 	}
+
+	s.notifyMutex.Lock()
+	defer s.notifyMutex.Unlock()
 	s.mutex.Unlock()
-	close(ch)
+
+	for i, c := range changes {
+		for _, w := range deliveries[i] {
+			if c.Kind != Unchanged || w.unchanged {
+				w.send(c)
+			}
+		}
+	}
 }
@@ -0,0 +1,153 @@
+package statestore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentAccess(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+
+	stop := make(chan struct{})
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				s.Store(fmt.Sprintf("device/%d", j%5), fmt.Sprintf("%d-%d", i, j))
+				s.Get(fmt.Sprintf("device/%d", (j+1)%5))
+			}
+		}(i)
+	}
+
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				s.StoreTTL(fmt.Sprintf("motion/%d", j%3), "on", time.Duration(j%5)*time.Millisecond)
+			}
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				s.WaitFor("device/1", "never", time.Microsecond)
+				s.WaitForNot("motion/0", "on", time.Microsecond)
+			}
+		}()
+	}
+
+	// watchers are closed while changes are delivered to them
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j < 200; j++ {
+			w := s.Watch("device/+", WithBuffer(1), WithOverflowPolicy(CloseOnOverflow))
+			p := s.WatchPrefix("motion/")
+			w.Close()
+			p.Close()
+		}
+	}()
+
+	// a consumer which is slower than the producers
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w := s.Watch("#")
+		defer w.Close()
+		for {
+			select {
+			case <-w.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		close(stop)
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Concurrent access didn't finish, the store is probably deadlocked")
+	}
+
+	state := s.Get("device/1")
+	if !s.WaitFor("device/1", state, time.Second) {
+		t.Fatalf("WaitFor doesn't return the current state '%s' after concurrent access", state)
+	}
+}
+
+func TestWaitForUnregisterWhileStoring(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+
+	stop := make(chan struct{})
+	stored := make(chan struct{})
+	go func() {
+		defer close(stored)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				s.Store("light", fmt.Sprintf("%d", i%2))
+			}
+		}
+	}()
+
+	// every WaitFor times out right away and unregisters its watcher while the store delivers changes
+	for i := 0; i < 1000; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i%3)*time.Microsecond)
+		s.WaitForContext(ctx, "light", "never")
+		cancel()
+	}
+
+	close(stop)
+	<-stored
+}
+
+func TestWaitFor(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		s.Store("tv/power", "on")
+	}()
+	if !s.WaitFor("tv/power", "on", time.Second) {
+		t.Fatal("WaitFor didn't notice the stored state")
+	}
+
+	// the current state satisfies WaitFor immediately
+	if !s.WaitFor("tv/power", "on", time.Millisecond) {
+		t.Fatal("WaitFor didn't return the current state")
+	}
+	if s.WaitFor("tv/power", "off", 10*time.Millisecond) {
+		t.Fatal("WaitFor returned without the expected state")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.WaitForContext(ctx, "tv/power", "off"); err != context.Canceled {
+		t.Fatalf("Unexpected error %v of cancelled WaitForContext", err)
+	}
+}
@@ -0,0 +1,104 @@
+package statestore
+
+import (
+	"time"
+)
+
+// Tx represents a transaction over several states, see Update
+type Tx struct {
+	store   *StateStore
+	pending map[string]*string
+	names   []string
+}
+
+// Get returns the given state including the changes of the transaction
+func (tx *Tx) Get(name string) string {
+	if state, ok := tx.pending[name]; ok {
+		if state == nil {
+			return ""
+		}
+		return *state
+	}
+
+	return tx.store.states[name]
+}
+
+// Store saves the given state when the transaction is committed
+func (tx *Tx) Store(name string, state string) {
+	tx.stage(name, &state)
+}
+
+// Delete removes the given state when the transaction is committed
+func (tx *Tx) Delete(name string) {
+	tx.stage(name, nil)
+}
+
+func (tx *Tx) stage(name string, state *string) {
+	if _, ok := tx.pending[name]; !ok {
+		tx.names = append(tx.names, name)
+	}
+	tx.pending[name] = state
+}
+
+// run calls the function with the transaction, the write lock is released if it panics
+func (tx *Tx) run(f func(tx *Tx) error) error {
+	returned := false
+	defer func() {
+		if !returned {
+			tx.store.mutex.Unlock()
+		}
+	}()
+
+	err := f(tx)
+	returned = true
+
+	return err
+}
+
+// CompareAndSwap stores the new state only if the current state equals the old one
+//
+// A state which is not stored at all equals an empty state.
+func (s *StateStore) CompareAndSwap(name string, old string, new string) bool {
+	s.mutex.Lock()
+	if s.states[name] != old {
+		s.mutex.Unlock()
+		return false
+	}
+
+	c := s.set(name, new, time.Now())
+	s.unlockAndNotify([]Change{c})
+
+	return true
+}
+
+// Update runs the function in a transaction and applies all stored states at once if it returns no error
+//
+// The write lock is held while the function runs, so it sees a consistent view of the states
+// and must not call any method of the state store itself.
+// Watchers receive the changes of the transaction after all of them were applied without any other change in between.
+// If the function panics, no state is changed and the panic is propagated.
+func (s *StateStore) Update(f func(tx *Tx) error) ([]Change, error) {
+	tx := &Tx{store: s, pending: map[string]*string{}}
+
+	s.mutex.Lock()
+	if err := tx.run(f); err != nil {
+		s.mutex.Unlock()
+		return nil, err
+	}
+
+	now := time.Now()
+	changes := make([]Change, 0, len(tx.names))
+	for _, name := range tx.names {
+		state := tx.pending[name]
+		if state == nil {
+			if c, ok := s.remove(name, now); ok {
+				changes = append(changes, c)
+			}
+			continue
+		}
+		changes = append(changes, s.set(name, *state, now))
+	}
+	s.unlockAndNotify(changes)
+
+	return changes, nil
+}
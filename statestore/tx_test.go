@@ -0,0 +1,137 @@
+package statestore
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+
+	// a missing state equals an empty one
+	if !s.CompareAndSwap("scene", "", "movie") {
+		t.Fatal("CompareAndSwap of a missing state failed")
+	}
+	if s.CompareAndSwap("scene", "", "music") {
+		t.Fatal("CompareAndSwap with a wrong old state succeeded")
+	}
+	if !s.CompareAndSwap("scene", "movie", "music") {
+		t.Fatal("CompareAndSwap with the current state failed")
+	}
+	if state := s.Get("scene"); state != "music" {
+		t.Fatalf("Unexpected state '%s' after CompareAndSwap", state)
+	}
+
+	// CompareAndSwap replaces a pending expiry like Store
+	s.StoreTTL("motion", "on", 10*time.Millisecond)
+	if !s.CompareAndSwap("motion", "on", "on") {
+		t.Fatal("CompareAndSwap of a state with TTL failed")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if state := s.Get("motion"); state != "on" {
+		t.Fatalf("State expired to '%s' after CompareAndSwap", state)
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+
+	s.Store("scene", "movie")
+	s.Store("light", "on")
+	w := s.WatchPrefix("")
+	defer w.Close()
+
+	changes, err := s.Update(func(tx *Tx) error {
+		tx.Store("a", "1")
+		tx.Store("b", tx.Get("scene"))
+		tx.Store("a", "2")
+		tx.Delete("light")
+		if state := tx.Get("light"); state != "" {
+			t.Errorf("Deleted state is '%s' within the transaction", state)
+		}
+		if state := tx.Get("a"); state != "2" {
+			t.Errorf("Stored state is '%s' within the transaction", state)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("Unexpected changes %v", changes)
+	}
+	if s.Get("a") != "2" || s.Get("b") != "movie" || s.Get("light") != "" {
+		t.Fatalf("Unexpected states %v after the transaction", s.Snapshot().States)
+	}
+
+	expected := []Change{
+		{Name: "a", Kind: Created, NewState: "2"},
+		{Name: "b", Kind: Created, NewState: "movie"},
+		{Name: "light", Kind: Deleted, OldState: "on"},
+	}
+	for _, e := range expected {
+		select {
+		case c := <-w.C:
+			if c.Name != e.Name || c.Kind != e.Kind || c.OldState != e.OldState || c.NewState != e.NewState {
+				t.Fatalf("Unexpected change %v instead of %v", c, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Change %v was not delivered", e)
+		}
+	}
+}
+
+func TestUpdateError(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+
+	s.Store("a", "1")
+	failure := errors.New("failure")
+	changes, err := s.Update(func(tx *Tx) error {
+		tx.Store("a", "2")
+		tx.Delete("a")
+		tx.Store("b", "2")
+		return failure
+	})
+	if err != failure || changes != nil {
+		t.Fatalf("Unexpected result %v, %v of failed transaction", changes, err)
+	}
+	if s.Get("a") != "1" || s.Get("b") != "" {
+		t.Fatalf("Failed transaction changed the states %v", s.Snapshot().States)
+	}
+}
+
+func TestUpdatePanic(t *testing.T) {
+	s := NewStateStore()
+	defer s.Close()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "failure" {
+				t.Fatalf("Unexpected panic %v", r)
+			}
+		}()
+		s.Update(func(tx *Tx) error {
+			tx.Store("a", "1")
+			panic("failure")
+		})
+	}()
+
+	// the store must not be locked anymore
+	done := make(chan struct{})
+	go func() {
+		s.Store("b", "2")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Store is locked after a panic in a transaction")
+	}
+	if s.Get("a") != "" {
+		t.Fatal("Panicking transaction changed the states")
+	}
+}
@@ -0,0 +1,164 @@
+package statestore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ErrNotStored is returned by the typed getters if there is no state for the given name
+var ErrNotStored = errors.New("State is not stored")
+
+// TypeError is returned by the typed getters if a state cannot be converted to the requested type
+type TypeError struct {
+	Name  string
+	State string
+	Type  string
+	Err   error
+}
+
+func (e *TypeError) Error() string {
+	return fmt.Sprintf("State %s with value '%s' is not of type %s: %s", e.Name, e.State, e.Type, e.Err)
+}
+
+// Codec converts arbitrary values to states and back
+type Codec interface {
+	Encode(v interface{}) (string, error)
+	Decode(state string, v interface{}) error
+}
+
+// JSONCodec converts values to states using their JSON representation
+var JSONCodec Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Encode(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+func (jsonCodec) Decode(state string, v interface{}) error {
+	return json.Unmarshal([]byte(state), v)
+}
+
+// StoreValue saves the given value encoded with the codec and returns the old state
+func (s *StateStore) StoreValue(name string, v interface{}, c Codec) (oldState string, changed bool, err error) {
+	state, err := c.Encode(v)
+	if err != nil {
+		return "", false, fmt.Errorf("Could not encode state %s: %s", name, err)
+	}
+
+	oldState, changed = s.Store(name, state)
+
+	return oldState, changed, nil
+}
+
+// GetValue decodes the given state with the codec into v
+func (s *StateStore) GetValue(name string, v interface{}, c Codec) error {
+	state, ok := s.lookup(name)
+	if !ok {
+		return ErrNotStored
+	}
+
+	if err := c.Decode(state, v); err != nil {
+		return &TypeError{Name: name, State: state, Type: fmt.Sprintf("%T", v), Err: err}
+	}
+
+	return nil
+}
+
+// StoreJSON saves the JSON representation of the given value and returns the old state
+func (s *StateStore) StoreJSON(name string, v interface{}) (oldState string, changed bool, err error) {
+	return s.StoreValue(name, v, JSONCodec)
+}
+
+// GetJSON decodes the given state as JSON into v
+func (s *StateStore) GetJSON(name string, v interface{}) error {
+	return s.GetValue(name, v, JSONCodec)
+}
+
+// StoreInt saves the given integer and returns the old state
+func (s *StateStore) StoreInt(name string, v int) (oldState string, changed bool) {
+	return s.Store(name, strconv.Itoa(v))
+}
+
+// GetInt returns the given state as integer
+func (s *StateStore) GetInt(name string) (int, error) {
+	state, ok := s.lookup(name)
+	if !ok {
+		return 0, ErrNotStored
+	}
+
+	v, err := strconv.Atoi(state)
+	if err != nil {
+		return 0, &TypeError{Name: name, State: state, Type: "int", Err: err}
+	}
+
+	return v, nil
+}
+
+// StoreFloat saves the given float and returns the old state
+func (s *StateStore) StoreFloat(name string, v float64) (oldState string, changed bool) {
+	return s.Store(name, strconv.FormatFloat(v, 'f', -1, 64))
+}
+
+// GetFloat returns the given state as float
+func (s *StateStore) GetFloat(name string) (float64, error) {
+	state, ok := s.lookup(name)
+	if !ok {
+		return 0, ErrNotStored
+	}
+
+	v, err := strconv.ParseFloat(state, 64)
+	if err != nil {
+		return 0, &TypeError{Name: name, State: state, Type: "float64", Err: err}
+	}
+
+	return v, nil
+}
+
+// StoreBool saves the given boolean and returns the old state
+func (s *StateStore) StoreBool(name string, v bool) (oldState string, changed bool) {
+	return s.Store(name, strconv.FormatBool(v))
+}
+
+// GetBool returns the given state as boolean
+func (s *StateStore) GetBool(name string) (bool, error) {
+	state, ok := s.lookup(name)
+	if !ok {
+		return false, ErrNotStored
+	}
+
+	v, err := strconv.ParseBool(state)
+	if err != nil {
+		return false, &TypeError{Name: name, State: state, Type: "bool", Err: err}
+	}
+
+	return v, nil
+}
+
+// StoreTime saves the given time in RFC 3339 format and returns the old state
+func (s *StateStore) StoreTime(name string, v time.Time) (oldState string, changed bool) {
+	return s.Store(name, v.Format(time.RFC3339Nano))
+}
+
+// GetTime returns the given state as time, it has to be in RFC 3339 format
+func (s *StateStore) GetTime(name string) (time.Time, error) {
+	state, ok := s.lookup(name)
+	if !ok {
+		return time.Time{}, ErrNotStored
+	}
+
+	v, err := time.Parse(time.RFC3339Nano, state)
+	if err != nil {
+		return time.Time{}, &TypeError{Name: name, State: state, Type: "time.Time", Err: err}
+	}
+
+	return v, nil
+}
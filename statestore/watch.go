@@ -0,0 +1,261 @@
+package statestore
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultWatchBuffer is the number of changes a watcher buffers if not configured otherwise
+const DefaultWatchBuffer = 16
+
+// ChangeKind describes how a state was changed
+type ChangeKind int
+
+const (
+	// Created means that the state was not stored before
+	Created ChangeKind = iota
+	// Updated means that the state was stored before with a different value
+	Updated
+	// Unchanged means that the state was stored again with the same value
+	Unchanged
+	// Deleted means that the state was removed
+	Deleted
+)
+
+var changeKindNames = map[ChangeKind]string{
+	Created:   "created",
+	Updated:   "updated",
+	Unchanged: "unchanged",
+	Deleted:   "deleted",
+}
+
+func (k ChangeKind) String() string {
+	if name, ok := changeKindNames[k]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// MarshalText encodes the kind by its name
+func (k ChangeKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes the kind from its name
+func (k *ChangeKind) UnmarshalText(b []byte) error {
+	for kind, name := range changeKindNames {
+		if name == string(b) {
+			*k = kind
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Change kind '%s' is not valid", b)
+}
+
+// Change represents a change of a single state
+type Change struct {
+	Name     string     `json:"name"`
+	Kind     ChangeKind `json:"kind"`
+	OldState string     `json:"old"`
+	NewState string     `json:"new"`
+	Time     time.Time  `json:"time"`
+}
+
+// OverflowPolicy defines what happens to a change when the buffer of a watcher is full
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest buffered change in favour of the new one
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the new change and keeps the buffered ones
+	DropNewest
+	// CloseOnOverflow closes the channel of the watcher, so the consumer notices that it missed changes
+	CloseOnOverflow
+)
+
+// WatchOption represents a configuration option for a watcher
+type WatchOption func(*Watcher)
+
+// WithBuffer sets the number of changes the watcher buffers for a slow consumer
+func WithBuffer(size int) WatchOption {
+	return func(w *Watcher) {
+		w.size = size
+	}
+}
+
+// WithUnchanged also delivers writes which did not change the state
+func WithUnchanged() WatchOption {
+	return func(w *Watcher) {
+		w.unchanged = true
+	}
+}
+
+// WithOverflowPolicy sets what happens to changes when the buffer of the watcher is full
+func WithOverflowPolicy(p OverflowPolicy) WatchOption {
+	return func(w *Watcher) {
+		w.policy = p
+	}
+}
+
+// Watcher receives the changes of one or several states
+//
+// By default only real changes are delivered, see WithUnchanged.
+// Storing a state never blocks on a watcher, if its buffer is full the overflow policy is applied.
+// A watcher has to be closed when it is not used anymore.
+type Watcher struct {
+	// C delivers the changes, it is closed when the watcher is closed
+	C <-chan Change
+
+	ch        chan Change
+	store     *StateStore
+	names     []string
+	match     func(name string) bool
+	size      int
+	policy    OverflowPolicy
+	unchanged bool
+	dropped   uint64
+	closed    bool
+	mutex     *sync.Mutex
+}
+
+// Watch returns a watcher for the changes of the given state
+//
+// The name may also be a pattern with the MQTT wildcards + and # to watch several states.
+func (s *StateStore) Watch(name string, options ...WatchOption) *Watcher {
+	if isPattern(name) {
+		return s.watch(nil, func(n string) bool {
+			return matchPattern(name, n)
+		}, options)
+	}
+
+	return s.watch([]string{name}, nil, options)
+}
+
+// WatchPrefix returns a watcher for the changes of all states whose name starts with the given prefix
+func (s *StateStore) WatchPrefix(prefix string, options ...WatchOption) *Watcher {
+	return s.watch(nil, func(n string) bool {
+		return strings.HasPrefix(n, prefix)
+	}, options)
+}
+
+func (s *StateStore) watch(names []string, match func(string) bool, options []WatchOption) *Watcher {
+	w := &Watcher{
+		store:  s,
+		names:  names,
+		match:  match,
+		size:   DefaultWatchBuffer,
+		policy: DropOldest,
+		mutex:  &sync.Mutex{},
+	}
+	for _, o := range options {
+		o(w)
+	}
+	if w.size < 1 {
+		w.size = 1
+	}
+	w.ch = make(chan Change, w.size)
+	w.C = w.ch
+
+	s.mutex.Lock()
+	if match != nil {
+		s.matchWatchers = append(s.matchWatchers, w)
+	}
+	for _, name := range names {
+		s.watchers[name] = append(s.watchers[name], w)
+	}
+	s.mutex.Unlock()
+
+	return w
+}
+
+// Close stops the delivery of changes and closes the channel of the watcher
+func (w *Watcher) Close() {
+	s := w.store
+
+	s.mutex.Lock()
+	if w.match != nil {
+		s.matchWatchers = removeWatcher(s.matchWatchers, w)
+	}
+	for _, name := range w.names {
+		if watchers := removeWatcher(s.watchers[name], w); len(watchers) > 0 {
+			s.watchers[name] = watchers
+		} else {
+			delete(s.watchers, name)
+		}
+	}
+	s.mutex.Unlock()
+
+	w.mutex.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.ch)
+	}
+	w.mutex.Unlock()
+}
+
+// Dropped returns the number of changes which were discarded because the buffer was full
+func (w *Watcher) Dropped() uint64 {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.dropped
+}
+
+// send delivers the change without blocking, applying the overflow policy if the buffer is full
+func (w *Watcher) send(c Change) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.closed {
+		return
+	}
+
+	select {
+	case w.ch <- c:
+		return
+	default:
+	}
+
+	w.dropped++
+	switch w.policy {
+	case DropOldest:
+		select {
+		case <-w.ch:
+		default:
+		}
+		select {
+		case w.ch <- c:
+		default:
+		}
+	case CloseOnOverflow:
+		w.closed = true
+		close(w.ch)
+	}
+}
+
+// matchingWatchers returns all watchers interested in the given state, the caller has to hold the lock
+func (s *StateStore) matchingWatchers(name string) []*Watcher {
+	watchers := append([]*Watcher{}, s.watchers[name]...)
+	for _, w := range s.matchWatchers {
+		if w.match(name) {
+			watchers = append(watchers, w)
+		}
+	}
+
+	return watchers
+}
+
+func removeWatcher(watchers []*Watcher, w *Watcher) []*Watcher {
+	result := []*Watcher{}
+	for _, registered := range watchers {
+		if registered != w {
+			result = append(result, registered)
+		}
+	}
+
+	return result
+}
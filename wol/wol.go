@@ -0,0 +1,149 @@
+package wol
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/frado1/libs/retry"
+	"github.com/frado1/libs/servicecheck"
+)
+
+// DefaultPort is the port magic packets are sent to if no other port is configured
+const DefaultPort = 9
+
+// MagicPacket builds the magic packet for the MAC address, followed by the SecureOn password if not empty
+func MagicPacket(mac net.HardwareAddr, password []byte) ([]byte, error) {
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("MAC address %s is not a valid EUI-48 address", mac)
+	}
+	if len(password) != 0 && len(password) != 4 && len(password) != 6 {
+		return nil, fmt.Errorf("SecureOn password has %d bytes, expected 4 or 6", len(password))
+	}
+
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	packet = append(packet, bytes.Repeat(mac, 16)...)
+	packet = append(packet, password...)
+
+	return packet, nil
+}
+
+// ParsePassword parses a SecureOn password in the notation of a MAC address (6 bytes) or an IPv4 address (4 bytes)
+func ParsePassword(s string) ([]byte, error) {
+	if s == "" {
+		return nil, nil
+	}
+	if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+		return []byte(ip.To4()), nil
+	}
+	if mac, err := net.ParseMAC(s); err == nil && len(mac) == 6 {
+		return []byte(mac), nil
+	}
+
+	return nil, fmt.Errorf("SecureOn password '%s' is not valid", s)
+}
+
+// Sender broadcasts magic packets via UDP
+type Sender struct {
+	// Interface sends the packets from the given network interface to its broadcast address if not empty
+	Interface string `yaml:"interface"`
+	// Address is the destination of the packets, defaults to the broadcast address of the interface or 255.255.255.255
+	Address string `yaml:"address"`
+	// Port defaults to DefaultPort
+	Port int `yaml:"port"`
+}
+
+// Send sends a single magic packet for the MAC address
+func (s Sender) Send(mac net.HardwareAddr, password []byte) error {
+	packet, err := MagicPacket(mac, password)
+	if err != nil {
+		return err
+	}
+
+	local, broadcast, err := s.interfaceAddresses()
+	if err != nil {
+		return err
+	}
+
+	address := s.Address
+	if address == "" {
+		address = broadcast
+	}
+	port := s.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+	remote, err := net.ResolveUDPAddr("udp4", net.JoinHostPort(address, strconv.Itoa(port)))
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialUDP("udp4", local, remote)
+	if err != nil {
+		return fmt.Errorf("Could not open connection to send magic packet to %s: %s", remote, err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("Could not send magic packet for %s to %s: %s", mac, remote, err)
+	}
+
+	return nil
+}
+
+// interfaceAddresses returns the local address and the broadcast address of the configured interface
+func (s Sender) interfaceAddresses() (*net.UDPAddr, string, error) {
+	if s.Interface == "" {
+		return nil, net.IPv4bcast.String(), nil
+	}
+
+	iface, err := net.InterfaceByName(s.Interface)
+	if err != nil {
+		return nil, "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.To4() == nil {
+			continue
+		}
+
+		ip := ipNet.IP.To4()
+		mask := ipNet.Mask
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		broadcast := make(net.IP, net.IPv4len)
+		for i := range ip {
+			broadcast[i] = ip[i] | ^mask[i]
+		}
+
+		return &net.UDPAddr{IP: ip}, broadcast.String(), nil
+	}
+
+	return nil, "", fmt.Errorf("Interface %s has no IPv4 address", s.Interface)
+}
+
+// WakeAndWait sends magic packets and waits for the service to become available according to the policy
+//
+// A magic packet is sent before every probe, so a lost packet doesn't prevent waking up the device.
+func WakeAndWait(ctx context.Context, s Sender, mac net.HardwareAddr, password []byte, p servicecheck.Probe, policy retry.Policy) servicecheck.WaitResult {
+	if _, err := MagicPacket(mac, password); err != nil {
+		return servicecheck.WaitResult{LastError: err}
+	}
+
+	wake := servicecheck.ProbeFunc(func(ctx context.Context) error {
+		if err := s.Send(mac, password); err != nil {
+			return err
+		}
+		return p.Probe(ctx)
+	})
+
+	return servicecheck.WaitForProbeRetry(ctx, wake, func() bool { return true }, policy)
+}
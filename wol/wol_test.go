@@ -0,0 +1,138 @@
+package wol
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/frado1/libs/retry"
+	"github.com/frado1/libs/servicecheck"
+)
+
+var testMAC = net.HardwareAddr{0x01, 0x23, 0x45, 0x67, 0x89, 0xab}
+
+// listen opens a local UDP socket and returns a sender for it
+func listen(t *testing.T) (*net.UDPConn, Sender) {
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return l, Sender{Address: "127.0.0.1", Port: l.LocalAddr().(*net.UDPAddr).Port}
+}
+
+func receive(t *testing.T, l *net.UDPConn) []byte {
+	b := make([]byte, 256)
+	l.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := l.ReadFromUDP(b)
+	if err != nil {
+		t.Fatalf("No magic packet was received: %s", err)
+	}
+
+	return b[:n]
+}
+
+func checkPacket(t *testing.T, packet []byte, mac net.HardwareAddr, password []byte) {
+	if len(packet) != 102+len(password) {
+		t.Fatalf("Magic packet has %d bytes", len(packet))
+	}
+	if !bytes.Equal(packet[:6], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+		t.Fatalf("Magic packet starts with %x", packet[:6])
+	}
+	for i := 0; i < 16; i++ {
+		if !bytes.Equal(packet[6+i*6:12+i*6], mac) {
+			t.Fatalf("Repetition %d of MAC address is %x", i, packet[6+i*6:12+i*6])
+		}
+	}
+	if !bytes.Equal(packet[102:], password) {
+		t.Fatalf("Magic packet ends with password %x", packet[102:])
+	}
+}
+
+func TestSend(t *testing.T) {
+	l, s := listen(t)
+	defer l.Close()
+
+	if err := s.Send(testMAC, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkPacket(t, receive(t, l), testMAC, nil)
+
+	password := []byte{0xc0, 0xa8, 0x00, 0x01, 0x02, 0x03}
+	if err := s.Send(testMAC, password); err != nil {
+		t.Fatal(err)
+	}
+	checkPacket(t, receive(t, l), testMAC, password)
+}
+
+func TestMagicPacketErrors(t *testing.T) {
+	if _, err := MagicPacket(testMAC[:3], nil); err == nil {
+		t.Error("Magic packet for a short MAC address was built")
+	}
+	if _, err := MagicPacket(net.HardwareAddr{0, 1, 2, 3, 4, 5, 6, 7}, nil); err == nil {
+		t.Error("Magic packet for an EUI-64 address was built")
+	}
+	if _, err := MagicPacket(testMAC, []byte{1, 2, 3}); err == nil {
+		t.Error("Magic packet with a password of 3 bytes was built")
+	}
+}
+
+func TestParsePassword(t *testing.T) {
+	tests := []struct {
+		s        string
+		password []byte
+	}{
+		{"", nil},
+		{"192.168.0.1", []byte{192, 168, 0, 1}},
+		{"01:02:03:04:05:06", []byte{1, 2, 3, 4, 5, 6}},
+		{"01-02-03-04-05-06", []byte{1, 2, 3, 4, 5, 6}},
+	}
+	for _, test := range tests {
+		password, err := ParsePassword(test.s)
+		if err != nil {
+			t.Errorf("Could not parse password '%s': %s", test.s, err)
+			continue
+		}
+		if !bytes.Equal(password, test.password) {
+			t.Errorf("Password '%s' was parsed as %x", test.s, password)
+		}
+	}
+
+	for _, s := range []string{"secret", "::1", "01:02:03:04:05:06:07:08", "01:02:03"} {
+		if password, err := ParsePassword(s); err == nil {
+			t.Errorf("Invalid password '%s' was parsed as %x", s, password)
+		}
+	}
+}
+
+func TestWakeAndWait(t *testing.T) {
+	l, s := listen(t)
+	defer l.Close()
+
+	// a port which refuses connections
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := closed.Addr().String()
+	closed.Close()
+
+	probe := servicecheck.DialProbe{Network: "tcp", Address: address, Timeout: time.Second}
+	policy := retry.Policy{Initial: time.Millisecond, MaxAttempts: 3}
+	r := WakeAndWait(context.Background(), s, testMAC, nil, probe, policy)
+	if r.Available || r.Attempts != 3 || r.LastError == nil {
+		t.Fatalf("Unexpected result %v", r)
+	}
+
+	// a packet is sent before every probe
+	for i := 0; i < 3; i++ {
+		checkPacket(t, receive(t, l), testMAC, nil)
+	}
+
+	r = WakeAndWait(context.Background(), s, testMAC[:3], nil, probe, policy)
+	if r.Available || r.Attempts != 0 || r.LastError == nil {
+		t.Fatalf("Unexpected result %v for an invalid MAC address", r)
+	}
+}